@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+type adminKey struct{}
+
+type adminToken struct {
+	name  string
+	token []byte
+}
+
+// AdminAuth пропускает к /api/admin только запросы с токеном администратора в заголовке Authorization: Bearer <token>
+type AdminAuth struct {
+	tokens []adminToken
+}
+
+// NewAdminAuth разбирает список токенов вида "name:token,name2:token2". Имя записывается в историю
+// изменений как автор действия. Пустой список закрывает доступ ко всем административным методам.
+func NewAdminAuth(spec string) (*AdminAuth, error) {
+	auth := &AdminAuth{}
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, token, ok := strings.Cut(pair, ":")
+		if !ok || name == "" || token == "" {
+			return nil, errors.New("admin token must be in name:token format")
+		}
+		auth.tokens = append(auth.tokens, adminToken{name: name, token: []byte(token)})
+	}
+	return auth, nil
+}
+
+func (a *AdminAuth) Enabled() bool {
+	return len(a.tokens) > 0
+}
+
+func (a *AdminAuth) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "admin token required", http.StatusUnauthorized)
+			return
+		}
+
+		name := a.authenticate([]byte(token))
+		if name == "" {
+			http.Error(w, "invalid admin token", http.StatusForbidden)
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), adminKey{}, name)))
+	}
+}
+
+// authenticate сравнивает токен со всеми известными за постоянное время, чтобы по времени ответа нельзя было подобрать токен
+func (a *AdminAuth) authenticate(token []byte) string {
+	var name string
+	for _, known := range a.tokens {
+		if subtle.ConstantTimeCompare(token, known.token) == 1 {
+			name = known.name
+		}
+	}
+	return name
+}
+
+// adminName имя администратора, прошедшего AdminAuth, или пустая строка
+func adminName(ctx context.Context) string {
+	name, _ := ctx.Value(adminKey{}).(string)
+	return name
+}
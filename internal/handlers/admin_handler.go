@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/DrummDaddy/Booking_service/internal/models"
+	"github.com/DrummDaddy/Booking_service/internal/repositories"
+	"github.com/DrummDaddy/Booking_service/internal/services"
+)
+
+type AdminHandler struct {
+	Service              *services.BookingService
+	ExpiryWorker         *services.ExpiryWorker
+	ReconciliationWorker *services.ReconciliationWorker
+}
+
+func (h *AdminHandler) ExpiryStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.ExpiryWorker.Stats())
+}
+
+func (h *AdminHandler) ReconciliationStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.ReconciliationWorker.Stats())
+}
+
+func (h *AdminHandler) Reconcile(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Incorrect request", http.StatusBadRequest)
+		return
+	}
+	if !req.To.IsZero() && !req.From.Before(req.To) {
+		http.Error(w, "from must be before to", http.StatusBadRequest)
+		return
+	}
+
+	report, err := h.Service.ReconcilePayments(r.Context(), req.From, req.To)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func (h *AdminHandler) PaymentReviews(w http.ResponseWriter, r *http.Request) {
+	reviews, err := h.Service.ListPaymentReviews(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reviews)
+}
+
+func (h *AdminHandler) GetEventFeePolicy(w http.ResponseWriter, r *http.Request) {
+	rules, err := h.Service.GetEventFeeRules(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"rules": rules})
+}
+
+func (h *AdminHandler) SetEventFeePolicy(w http.ResponseWriter, r *http.Request) {
+	h.setFeePolicy(w, r, h.Service.SetEventFeePolicy)
+}
+
+func (h *AdminHandler) SetOrganizerFeePolicy(w http.ResponseWriter, r *http.Request) {
+	h.setFeePolicy(w, r, h.Service.SetOrganizerFeePolicy)
+}
+
+func (h *AdminHandler) setFeePolicy(w http.ResponseWriter, r *http.Request, save func(context.Context, string, []models.FeeRule) (*models.FeePolicy, error)) {
+	var req struct {
+		Rules []models.FeeRule `json:"rules"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Incorrect request", http.StatusBadRequest)
+		return
+	}
+
+	policy, err := save(r.Context(), r.PathValue("id"), req.Rules)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policy)
+}
+
+func (h *AdminHandler) ListPromoCodes(w http.ResponseWriter, r *http.Request) {
+	promos, err := h.Service.ListPromoCodes(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(promos)
+}
+
+func (h *AdminHandler) CreatePromoCode(w http.ResponseWriter, r *http.Request) {
+	var promo models.PromoCode
+	if err := json.NewDecoder(r.Body).Decode(&promo); err != nil {
+		http.Error(w, "Incorrect request", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Service.CreatePromoCode(r.Context(), &promo); err != nil {
+		if errors.Is(err, repositories.ErrPromoCodeExists) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(promo)
+}
+
+func (h *AdminHandler) RefundBooking(w http.ResponseWriter, r *http.Request) {
+	var req models.RefundRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Incorrect request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	refunds, err := h.Service.RefundBooking(r.Context(), r.PathValue("id"), req, models.AdminActor(adminName(r.Context())))
+	var partial *services.PartialRefundError
+	if errors.As(err, &partial) {
+		writePartialRefund(w, nil, partial)
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if models.HasRefundStatus(refunds, models.RefundStatusPending) {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	json.NewEncoder(w).Encode(refunds)
+}
+
+func (h *AdminHandler) ListRefunds(w http.ResponseWriter, r *http.Request) {
+	refunds, err := h.Service.ListRefunds(r.Context(), r.PathValue("id"), "")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(refunds)
+}
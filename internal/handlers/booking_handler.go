@@ -2,9 +2,11 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/DrummDaddy/Booking_service/internal/models"
 	"github.com/DrummDaddy/Booking_service/internal/services"
 	"net/http"
+	"strconv"
 )
 
 type BookingHandler struct {
@@ -31,10 +33,176 @@ func (h *BookingHandler) CreateBooking(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// Quote считает корзину без резерва, чтобы показывать итог при изменении количества
+func (h *BookingHandler) Quote(w http.ResponseWriter, r *http.Request) {
+	var req models.BookingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Incorrect request", http.StatusBadRequest)
+		return
+	}
+	req.UserID = r.Header.Get("X-USER-ID")
+
+	quote, err := h.Service.QuoteBooking(r.Context(), &req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(quote)
+}
+
+func (h *BookingHandler) GetBooking(w http.ResponseWriter, r *http.Request) {
+	booking, err := h.Service.Getbooking(r.Context(), r.PathValue("id"), r.Header.Get("X-USER-ID"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(booking)
+}
+
+func (h *BookingHandler) ListBookings(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	req := models.BookingListRequest{
+		UserID:  r.Header.Get("X-USER-ID"),
+		EventID: query.Get("event_id"),
+		Status:  models.BookingStatus(query.Get("status")),
+		Cursor:  query.Get("cursor"),
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			http.Error(w, "Incorrect limit", http.StatusBadRequest)
+			return
+		}
+		req.Limit = n
+	}
+
+	switch query.Get("sort") {
+	case "", "-created_at":
+	case "created_at":
+		req.Ascending = true
+	default:
+		http.Error(w, "Incorrect sort", http.StatusBadRequest)
+		return
+	}
+
+	list, err := h.Service.ListBookings(r.Context(), &req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func (h *BookingHandler) CancelBooking(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Incorrect request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	booking, err := h.Service.CancelUserBooking(r.Context(), r.PathValue("id"), r.Header.Get("X-USER-ID"), req.Reason)
+	var partial *services.PartialRefundError
+	if errors.As(err, &partial) {
+		writePartialRefund(w, booking, partial)
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if booking.Status != models.BookingStatusCancelled {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	json.NewEncoder(w).Encode(booking)
+}
+
+func (h *BookingHandler) ExtendReservation(w http.ResponseWriter, r *http.Request) {
+	booking, err := h.Service.ExtendReservation(r.Context(), r.PathValue("id"), r.Header.Get("X-USER-ID"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(booking)
+}
+
+func (h *BookingHandler) CancelTickets(w http.ResponseWriter, r *http.Request) {
+	var req models.CancelTicketsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Incorrect request", http.StatusBadRequest)
+		return
+	}
+
+	booking, refunds, err := h.Service.CancelTickets(r.Context(), r.PathValue("id"), r.Header.Get("X-USER-ID"), req)
+	var partial *services.PartialRefundError
+	if errors.As(err, &partial) {
+		writePartialRefund(w, booking, partial)
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if models.HasRefundStatus(refunds, models.RefundStatusPending) {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	json.NewEncoder(w).Encode(booking)
+}
+
+func (h *BookingHandler) ModifyBooking(w http.ResponseWriter, r *http.Request) {
+	var req models.ModificationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Incorrect request", http.StatusBadRequest)
+		return
+	}
+
+	modification, err := h.Service.ModifyBooking(r.Context(), r.PathValue("id"), r.Header.Get("X-USER-ID"), req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if modification.Status == models.ModificationStatusPending {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	json.NewEncoder(w).Encode(modification)
+}
+
+func (h *BookingHandler) ListRefunds(w http.ResponseWriter, r *http.Request) {
+	refunds, err := h.Service.ListRefunds(r.Context(), r.PathValue("id"), r.Header.Get("X-USER-ID"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(refunds)
+}
+
 func (h *BookingHandler) CreatePayment(w http.ResponseWriter, r *http.Request) {
 	type paymentReq struct {
 		BookingID string `json:"booking_id"`
 		ReturnURL string `json:"return_url"`
+		// Контакты для отправки чека
+		Email string `json:"email"`
+		Phone string `json:"phone"`
 	}
 	var req paymentReq
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -42,9 +210,12 @@ func (h *BookingHandler) CreatePayment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	paymentURL, err := h.Service.CreatePayment(r.Context(), req.BookingID, req.ReturnURL)
+	paymentURL, err := h.Service.CreatePayment(r.Context(), req.BookingID, r.Header.Get("X-USER-ID"), req.ReturnURL, models.ReceiptCustomer{
+		Email: req.Email,
+		Phone: req.Phone,
+	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, err)
 		return
 	}
 	json.NewEncoder(w).Encode(paymentURL)
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/DrummDaddy/Booking_service/internal/models"
+	"github.com/DrummDaddy/Booking_service/internal/repositories"
+	"github.com/DrummDaddy/Booking_service/internal/services"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func writeError(w http.ResponseWriter, err error) {
+	var transitionErr *models.TransitionError
+	var providerErr *services.ProviderError
+
+	switch {
+	case errors.Is(err, services.ErrBookingNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.As(err, &transitionErr), errors.Is(err, repositories.ErrStatusChanged):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, services.ErrRefundRejected):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, services.ErrSandboxTimeout), errors.Is(err, context.DeadlineExceeded):
+		log.Printf("request failed: %v", err)
+		http.Error(w, "upstream timeout", http.StatusGatewayTimeout)
+	case errors.As(err, &providerErr):
+		// Отказ провайдера окончателен, а 5xx или обрыв связи можно повторить
+		if providerErr.StatusCode >= 400 && providerErr.StatusCode < 500 {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		log.Printf("request failed: %v", err)
+		http.Error(w, "payment provider unavailable", http.StatusBadGateway)
+	case isInternalError(err):
+		// Подробности сбоя хранилища клиенту не отдаём
+		log.Printf("request failed: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// writePartialRefund отвечает на возврат, который провайдер принял только частично: в ответе все части
+// со статусами и ошибками, чтобы было видно, какие деньги вернутся, а какой возврат нужно оформить заново
+func writePartialRefund(w http.ResponseWriter, booking *models.Booking, partial *services.PartialRefundError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusMultiStatus)
+	json.NewEncoder(w).Encode(struct {
+		Error   string          `json:"error"`
+		Booking *models.Booking `json:"booking,omitempty"`
+		Refunds []models.Refund `json:"refunds"`
+	}{partial.Error(), booking, partial.Refunds})
+}
+
+// isInternalError сбой инфраструктуры, а не ошибка в запросе: такой ответ нельзя запоминать по Idempotency-Key,
+// повтор того же запроса может пройти
+func isInternalError(err error) bool {
+	var serverErr mongo.ServerError
+	var netErr net.Error
+
+	return errors.As(err, &serverErr) ||
+		errors.As(err, &netErr) ||
+		mongo.IsNetworkError(err) ||
+		mongo.IsTimeout(err) ||
+		errors.Is(err, mongo.ErrClientDisconnected) ||
+		errors.Is(err, context.Canceled)
+}
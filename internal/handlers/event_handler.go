@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/DrummDaddy/Booking_service/internal/services"
+)
+
+type EventHandler struct {
+	Service *services.BookingService
+}
+
+func (h *EventHandler) GetInventory(w http.ResponseWriter, r *http.Request) {
+	inventory, err := h.Service.GetEventInventory(r.Context(), r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(inventory)
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/DrummDaddy/Booking_service/internal/models"
+	"github.com/DrummDaddy/Booking_service/internal/services"
+)
+
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// Idempotent повторяет сохранённый ответ на запрос с тем же Idempotency-Key вместо повторного выполнения.
+// Ключ действует в пределах scope и пользователя; запросы без заголовка выполняются как обычно.
+func Idempotent(store *services.IdempotencyStore, scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > 255 {
+			http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Incorrect request", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		userID := r.Header.Get("X-USER-ID")
+		if name := adminName(r.Context()); name != "" {
+			userID = models.AdminActor(name)
+		}
+		storeKey := "idempotency:" + scope + ":" + userID + ":" + key
+
+		hash := sha256.New()
+		hash.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+		hash.Write(body)
+		fingerprint := hex.EncodeToString(hash.Sum(nil))
+
+		stored, claim, err := store.Begin(r.Context(), storeKey, fingerprint)
+		switch {
+		case errors.Is(err, services.ErrIdempotencyKeyReused):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		case errors.Is(err, services.ErrIdempotencyKeyInProcess):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			log.Printf("idempotency: begin %s: %v", storeKey, err)
+			http.Error(w, "idempotency storage unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		if stored != nil {
+			if stored.ContentType != "" {
+				w.Header().Set("Content-Type", stored.ContentType)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.StatusCode)
+			w.Write(stored.Body)
+			return
+		}
+
+		// Ключ освобождаем и сохраняем ответ даже после отмены запроса клиентом
+		ctx := context.WithoutCancel(r.Context())
+		abort := func() {
+			if err := store.Abort(ctx, claim); err != nil {
+				log.Printf("idempotency: abort %s: %v", storeKey, err)
+			}
+		}
+		defer func() {
+			if p := recover(); p != nil {
+				abort()
+				panic(p)
+			}
+		}()
+
+		// Запрос не должен пережить метку ключа, иначе его повтор выполнится параллельно
+		reqCtx, cancel := context.WithTimeout(r.Context(), store.RequestTimeout())
+		defer cancel()
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r.WithContext(reqCtx))
+
+		// Запоминаем только окончательные ответы, после сбоя или конфликта клиент может повторить запрос
+		if !isDefinitiveStatus(rec.status) {
+			abort()
+			return
+		}
+
+		err = store.Complete(ctx, claim, &services.IdempotentResponse{
+			Fingerprint: fingerprint,
+			StatusCode:  rec.status,
+			ContentType: rec.Header().Get("Content-Type"),
+			Body:        rec.body.Bytes(),
+		})
+		if err != nil {
+			log.Printf("idempotency: complete %s: %v", storeKey, err)
+		}
+	}
+}
+
+func isDefinitiveStatus(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusConflict, http.StatusTooManyRequests:
+		return false
+	}
+	return status < http.StatusInternalServerError
+}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if !rr.wroteHeader {
+		rr.status = status
+		rr.wroteHeader = true
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	rr.wroteHeader = true
+	rr.body.Write(b)
+	return rr.ResponseWriter.Write(b)
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/DrummDaddy/Booking_service/internal/services"
+)
+
+const maxWebhookBodySize = 1 << 20
+
+type PaymentWebhookHandler struct {
+	Service  *services.BookingService
+	Verifier *services.WebhookVerifier
+}
+
+func (h *PaymentWebhookHandler) HandleWebhook(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBodySize))
+	if err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Verifier.Verify(r, body); err != nil {
+		log.Printf("payment webhook from %s rejected: %v", r.RemoteAddr, err)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	err = h.Service.ProcessPaymentNotification(r.Context(), body, r.RemoteAddr)
+	switch {
+	case errors.Is(err, services.ErrInvalidNotification):
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	case err != nil:
+		// Не 2xx, чтобы провайдер повторил уведомление
+		log.Printf("payment webhook processing failed: %v", err)
+		http.Error(w, "processing failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+type StatusTransition struct {
+	From   BookingStatus `json:"from,omitempty" bson:"from,omitempty"`
+	To     BookingStatus `json:"to" bson:"to"`
+	Reason string        `json:"reason,omitempty" bson:"reason,omitempty"`
+	Actor  string        `json:"actor" bson:"actor"`
+	At     time.Time     `json:"at" bson:"at"`
+}
+
+const ActorSystem = "system"
+
+func UserActor(userID string) string {
+	return "user:" + userID
+}
+
+// AdminActor действия через /api/admin от имени администратора из ADMIN_TOKENS
+func AdminActor(name string) string {
+	return "admin:" + name
+}
+
+// Разрешённые переходы между статусами брони. Статусы без исходящих переходов финальные.
+var bookingTransitions = map[BookingStatus][]BookingStatus{
+	BookingStatusReserved: {BookingStatusPending, BookingStatusConfirmed, BookingStatusCancelled, BookingStatusExpired},
+	BookingStatusPending:  {BookingStatusReserved, BookingStatusConfirmed, BookingStatusCancelled, BookingStatusExpired},
+	// Подтверждённую бронь отменяют только после принятого провайдером возврата
+	BookingStatusConfirmed: {BookingStatusCancelled},
+}
+
+type TransitionError struct {
+	From BookingStatus
+	To   BookingStatus
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("booking cannot move from %s to %s", e.From, e.To)
+}
+
+func (s BookingStatus) CanTransitionTo(to BookingStatus) bool {
+	for _, allowed := range bookingTransitions[s] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+func (s BookingStatus) IsFinal() bool {
+	return len(bookingTransitions[s]) == 0
+}
+
+func NewStatusTransition(from, to BookingStatus, reason, actor string) (StatusTransition, error) {
+	if !from.CanTransitionTo(to) {
+		return StatusTransition{}, &TransitionError{From: from, To: to}
+	}
+
+	return StatusTransition{
+		From:   from,
+		To:     to,
+		Reason: reason,
+		Actor:  actor,
+		At:     time.Now(),
+	}, nil
+}
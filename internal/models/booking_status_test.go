@@ -0,0 +1,64 @@
+package models
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewStatusTransition(t *testing.T) {
+	tests := []struct {
+		from, to BookingStatus
+		allowed  bool
+	}{
+		{BookingStatusReserved, BookingStatusPending, true},
+		{BookingStatusReserved, BookingStatusConfirmed, true},
+		{BookingStatusReserved, BookingStatusCancelled, true},
+		{BookingStatusReserved, BookingStatusExpired, true},
+		{BookingStatusPending, BookingStatusReserved, true},
+		{BookingStatusPending, BookingStatusConfirmed, true},
+		{BookingStatusPending, BookingStatusExpired, true},
+		{BookingStatusConfirmed, BookingStatusCancelled, true},
+		// Оплаченную бронь нельзя вернуть в резерв или снять по истечении
+		{BookingStatusConfirmed, BookingStatusReserved, false},
+		{BookingStatusConfirmed, BookingStatusExpired, false},
+		{BookingStatusCancelled, BookingStatusReserved, false},
+		{BookingStatusExpired, BookingStatusConfirmed, false},
+		{BookingStatusReserved, BookingStatusReserved, false},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.from)+"->"+string(tt.to), func(t *testing.T) {
+			transition, err := NewStatusTransition(tt.from, tt.to, "test", ActorSystem)
+			if !tt.allowed {
+				var transitionErr *TransitionError
+				if !errors.As(err, &transitionErr) || transitionErr.From != tt.from || transitionErr.To != tt.to {
+					t.Fatalf("got %v, want TransitionError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if transition.From != tt.from || transition.To != tt.to || transition.Actor != ActorSystem || transition.At.IsZero() {
+				t.Errorf("transition = %+v", transition)
+			}
+		})
+	}
+}
+
+func TestBookingStatusIsFinal(t *testing.T) {
+	tests := []struct {
+		status BookingStatus
+		final  bool
+	}{
+		{BookingStatusReserved, false},
+		{BookingStatusPending, false},
+		{BookingStatusConfirmed, false},
+		{BookingStatusCancelled, true},
+		{BookingStatusExpired, true},
+	}
+	for _, tt := range tests {
+		if got := tt.status.IsFinal(); got != tt.final {
+			t.Errorf("%s.IsFinal() = %v, want %v", tt.status, got, tt.final)
+		}
+	}
+}
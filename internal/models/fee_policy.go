@@ -0,0 +1,57 @@
+package models
+
+import (
+	"time"
+
+	"github.com/DrummDaddy/Booking_service/internal/money"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type FeeRuleType string
+
+const (
+	// FeeRulePercent процент от стоимости билетов (BasisPoints, 1000 = 10%)
+	FeeRulePercent FeeRuleType = "percent"
+	// FeeRulePerTicket фиксированная сумма за каждый билет
+	FeeRulePerTicket FeeRuleType = "per_ticket"
+	// FeeRulePerOrder фиксированная сумма один раз за бронь
+	FeeRulePerOrder FeeRuleType = "per_order"
+)
+
+// FeeRule одно правило сбора. Фиксированные суммы задаются по валютам, так как бронь может быть в любой из них.
+type FeeRule struct {
+	Name        string                  `json:"name" bson:"name"`
+	Type        FeeRuleType             `json:"type" bson:"type"`
+	BasisPoints int64                   `json:"basis_points,omitempty" bson:"basis_points,omitempty"`
+	Amounts     map[string]money.Amount `json:"amounts,omitempty" bson:"amounts,omitempty"`
+	// MinPerTicket нижняя граница процентного сбора за билет
+	MinPerTicket map[string]money.Amount `json:"min_per_ticket,omitempty" bson:"min_per_ticket,omitempty"`
+	// Cap верхняя граница суммы правила за всю бронь
+	Cap map[string]money.Amount `json:"cap,omitempty" bson:"cap,omitempty"`
+	// TicketTypeIDs ограничивает правило типами билетов, пустой список означает все типы
+	TicketTypeIDs []primitive.ObjectID `json:"ticket_type_ids,omitempty" bson:"ticket_type_ids,omitempty"`
+	// Absorbed сбор оплачивает организатор: он попадает в расшифровку, но не в сумму к оплате
+	Absorbed bool `json:"absorbed" bson:"absorbed"`
+}
+
+func (r *FeeRule) AppliesTo(ticketTypeID primitive.ObjectID) bool {
+	return len(r.TicketTypeIDs) == 0 || containsID(r.TicketTypeIDs, ticketTypeID)
+}
+
+// FeePolicy набор правил сбора для мероприятия или организатора.
+// Политика мероприятия важнее политики организатора.
+type FeePolicy struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	EventID     primitive.ObjectID `json:"event_id,omitempty" bson:"event_id,omitempty"`
+	OrganizerID primitive.ObjectID `json:"organizer_id,omitempty" bson:"organizer_id,omitempty"`
+	Rules       []FeeRule          `json:"rules" bson:"rules"`
+	UpdatedAt   time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// AppliedFee результат применения правила к брони, сохраняется в брони как расшифровка сбора
+type AppliedFee struct {
+	Rule     string       `json:"rule" bson:"rule"`
+	Type     FeeRuleType  `json:"type" bson:"type"`
+	Amount   money.Amount `json:"amount" bson:"amount"`
+	Absorbed bool         `json:"absorbed" bson:"absorbed"`
+}
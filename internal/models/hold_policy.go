@@ -0,0 +1,82 @@
+package models
+
+import "time"
+
+const (
+	defaultHoldTTLMinutes       = 15
+	defaultHoldExtensionMinutes = 10
+	defaultHoldMaxExtensions    = 2
+	defaultHoldMaxMinutes       = 45
+	defaultPaymentHoldMinutes   = 15
+)
+
+// HoldPolicy сколько держится резерв брони и как его можно продлить. Без политики у мероприятия
+// действуют значения по умолчанию. Нулевые TTL, MaxHold и PaymentHold тоже берутся по умолчанию,
+// а ExtensionMinutes и MaxExtensions как есть: политика с нулём продлений запрещает продление.
+type HoldPolicy struct {
+	TTLMinutes       int `json:"ttl_minutes,omitempty" bson:"ttl_minutes,omitempty"`
+	ExtensionMinutes int `json:"extension_minutes" bson:"extension_minutes"`
+	MaxExtensions    int `json:"max_extensions" bson:"max_extensions"`
+	// MaxHoldMinutes предел удержания от создания брони, в том числе с автоматическим продлением при оплате
+	MaxHoldMinutes int `json:"max_hold_minutes,omitempty" bson:"max_hold_minutes,omitempty"`
+	// PaymentHoldMinutes на сколько резерв продлевается автоматически, когда начинается оплата
+	PaymentHoldMinutes int `json:"payment_hold_minutes,omitempty" bson:"payment_hold_minutes,omitempty"`
+}
+
+func (e *Event) HoldPolicy() HoldPolicy {
+	if e.Hold == nil {
+		return HoldPolicy{
+			TTLMinutes:         defaultHoldTTLMinutes,
+			ExtensionMinutes:   defaultHoldExtensionMinutes,
+			MaxExtensions:      defaultHoldMaxExtensions,
+			MaxHoldMinutes:     defaultHoldMaxMinutes,
+			PaymentHoldMinutes: defaultPaymentHoldMinutes,
+		}
+	}
+
+	policy := *e.Hold
+	if policy.TTLMinutes <= 0 {
+		policy.TTLMinutes = defaultHoldTTLMinutes
+	}
+	if policy.MaxHoldMinutes <= 0 {
+		policy.MaxHoldMinutes = defaultHoldMaxMinutes
+	}
+	if policy.MaxHoldMinutes < policy.TTLMinutes {
+		policy.MaxHoldMinutes = policy.TTLMinutes
+	}
+	if policy.PaymentHoldMinutes <= 0 {
+		policy.PaymentHoldMinutes = defaultPaymentHoldMinutes
+	}
+	return policy
+}
+
+func (p HoldPolicy) TTL() time.Duration {
+	return time.Duration(p.TTLMinutes) * time.Minute
+}
+
+// Deadline момент, дольше которого бронь созданная в createdAt не удерживается
+func (p HoldPolicy) Deadline(createdAt time.Time) time.Time {
+	return createdAt.Add(time.Duration(p.MaxHoldMinutes) * time.Minute)
+}
+
+// Extended срок резерва после продления пользователем, не дальше Deadline
+func (p HoldPolicy) Extended(createdAt, reservedUntil time.Time) time.Time {
+	return p.capped(createdAt, reservedUntil.Add(time.Duration(p.ExtensionMinutes)*time.Minute))
+}
+
+// PaymentHoldUntil срок резерва на время оплаты, начатой в now, не дальше Deadline
+func (p HoldPolicy) PaymentHoldUntil(createdAt, now time.Time) time.Time {
+	return p.capped(createdAt, now.Add(time.Duration(p.PaymentHoldMinutes)*time.Minute))
+}
+
+// TopUpDeadline до какого момента обмен билетов, начатый в now, ждёт доплаты
+func (p HoldPolicy) TopUpDeadline(now time.Time) time.Time {
+	return now.Add(time.Duration(p.PaymentHoldMinutes) * time.Minute)
+}
+
+func (p HoldPolicy) capped(createdAt, until time.Time) time.Time {
+	if deadline := p.Deadline(createdAt); until.After(deadline) {
+		return deadline
+	}
+	return until
+}
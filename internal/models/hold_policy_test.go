@@ -0,0 +1,66 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHoldPolicyDefaults(t *testing.T) {
+	tests := []struct {
+		name string
+		hold *HoldPolicy
+		want HoldPolicy
+	}{
+		{
+			name: "no policy",
+			want: HoldPolicy{TTLMinutes: 15, ExtensionMinutes: 10, MaxExtensions: 2, MaxHoldMinutes: 45, PaymentHoldMinutes: 15},
+		},
+		{
+			// Продления не подставляются по умолчанию: ноль запрещает продление
+			name: "zero extensions kept",
+			hold: &HoldPolicy{TTLMinutes: 20},
+			want: HoldPolicy{TTLMinutes: 20, MaxHoldMinutes: 45, PaymentHoldMinutes: 15},
+		},
+		{
+			name: "max hold not shorter than ttl",
+			hold: &HoldPolicy{TTLMinutes: 60, MaxHoldMinutes: 30, PaymentHoldMinutes: 5},
+			want: HoldPolicy{TTLMinutes: 60, MaxHoldMinutes: 60, PaymentHoldMinutes: 5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := &Event{Hold: tt.hold}
+			if got := event.HoldPolicy(); got != tt.want {
+				t.Errorf("HoldPolicy() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHoldPolicyDeadlines(t *testing.T) {
+	created := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return created.Add(time.Duration(minutes) * time.Minute) }
+	policy := (&Event{}).HoldPolicy()
+
+	if got := policy.Deadline(created); !got.Equal(at(45)) {
+		t.Errorf("Deadline = %v, want %v", got, at(45))
+	}
+
+	tests := []struct {
+		name string
+		got  time.Time
+		want time.Time
+	}{
+		{name: "extended", got: policy.Extended(created, at(15)), want: at(25)},
+		{name: "extension capped by deadline", got: policy.Extended(created, at(40)), want: at(45)},
+		{name: "payment hold", got: policy.PaymentHoldUntil(created, at(10)), want: at(25)},
+		{name: "payment hold capped by deadline", got: policy.PaymentHoldUntil(created, at(40)), want: at(45)},
+		// Доплата за обмен оплаченной брони не ограничена сроком резерва
+		{name: "top-up not capped", got: policy.TopUpDeadline(at(60)), want: at(75)},
+	}
+	for _, tt := range tests {
+		if !tt.got.Equal(tt.want) {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package models
 import (
 	"time"
 
+	"github.com/DrummDaddy/Booking_service/internal/money"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -23,13 +24,33 @@ type Booking struct {
 	Status  BookingStatus      `json:"status" bson:"status"`
 	Tickets []BookingTicket    `json:"tickets" bson:"tickets"`
 
-	PaymentID   string  `json:"payment_id" bson:"payment_id"`
-	Subtotal    float64 `json:"subtotal" bson:"subtotal"`
-	ServiceFree float64 `json:"service_free" bson:"service_free"`
-	TotalAmount float64 `json:"total_amount" bson:"total_amount"`
-	Currency    string  `json:"currency" bson:"currency"`
+	PaymentID   string       `json:"payment_id" bson:"payment_id"`
+	Subtotal    money.Amount `json:"subtotal" bson:"subtotal"`
+	ServiceFree money.Amount `json:"service_free" bson:"service_free"`
+	TotalAmount money.Amount `json:"total_amount" bson:"total_amount"`
+	Currency    string       `json:"currency" bson:"currency"`
+	// Fees расшифровка сбора по правилам. ServiceFree сумма сборов, которые платит покупатель
+	Fees []AppliedFee `json:"fees,omitempty" bson:"fees,omitempty"`
+	// FeeRules правила сборов на момент бронирования: по ним пересчитываются суммы при отмене части
+	// билетов и обмене, чтобы смена политики не меняла сбор уже оформленной брони
+	FeeRules []FeeRule `json:"-" bson:"fee_rules,omitempty"`
+
+	// Discount скидка по промокоду, TotalAmount = Subtotal - Discount + ServiceFree
+	Discount money.Amount  `json:"discount" bson:"discount"`
+	Promo    *BookingPromo `json:"promo,omitempty" bson:"promo,omitempty"`
 
 	ReservedUntil time.Time `json:"reserved_until" bson:"reserved_until"`
+	// HoldExtensions сколько раз пользователь продлил резерв
+	HoldExtensions int `json:"hold_extensions" bson:"hold_extensions,omitempty"`
+
+	// RefundedAmount сумма возвратов в обработке и проведённых, RefundedFee её часть за сервисный сбор.
+	// Отменённые провайдером возвраты из этих сумм вычитаются.
+	RefundedAmount money.Amount `json:"refunded_amount" bson:"refunded_amount"`
+	RefundedFee    money.Amount `json:"refunded_fee" bson:"refunded_fee"`
+
+	Modifications []BookingModification `json:"modifications,omitempty" bson:"modifications,omitempty"`
+
+	History []StatusTransition `json:"history,omitempty" bson:"history,omitempty"`
 
 	CreatedAt time.Time `json:"created_at" bson:"created_at"`
 	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
@@ -39,9 +60,14 @@ type BookingTicket struct {
 	TicketTypeID   primitive.ObjectID `json:"ticket_type_id" bson:"ticket_type_id"`
 	TicketTypeName string             `json:"ticket_type_name" bson:"ticket_type_name"`
 	Quantity       int                `json:"quantity" bson:"quantity"`
-	UnitPrice      float64            `json:"unit_price" bson:"unit_price"`
-	TotalPrice     float64            `json:"total_price" bson:"total_price"`
+	UnitPrice      money.Amount       `json:"unit_price" bson:"unit_price"`
+	TotalPrice     money.Amount       `json:"total_price" bson:"total_price"`
+	PriceTier      string             `json:"price_tier,omitempty" bson:"price_tier,omitempty"`
 	Seats          []Seat             `json:"seats,omitempty" bson:"seats,omitempty"`
+
+	// RefundedQuantity сколько билетов строки возвращено, RefundedSeats их места
+	RefundedQuantity int      `json:"refunded_quantity,omitempty" bson:"refunded_quantity,omitempty"`
+	RefundedSeats    []string `json:"refunded_seats,omitempty" bson:"refunded_seats,omitempty"`
 }
 
 type Seat struct {
@@ -54,7 +80,10 @@ type Seat struct {
 type BookingRequest struct {
 	EventID string            `json:"event_id"`
 	Tickets []TicketSelection `json:"tickets"`
-	UserID  string            `json:"-"`
+	// Currency валюта оплаты, по умолчанию валюта мероприятия
+	Currency  string `json:"currency,omitempty"`
+	PromoCode string `json:"promo_code,omitempty"`
+	UserID    string `json:"-"`
 }
 
 type TicketSelection struct {
@@ -63,25 +92,158 @@ type TicketSelection struct {
 	Seats    []Seat `json:"seats,omitempty"`
 }
 
+// TicketLineRequest билеты строки брони, которые возвращаются или отменяются
+type TicketLineRequest struct {
+	// TicketIndex номер строки в Booking.Tickets
+	TicketIndex int `json:"ticket_index"`
+	Quantity    int `json:"quantity"`
+	// Seats места у билетов с рассадкой, по умолчанию последние невозвращённые места строки
+	Seats []string `json:"seats,omitempty"`
+}
+
+// CancelTicketsRequest отмена части билетов брони
+type CancelTicketsRequest struct {
+	Lines  []TicketLineRequest `json:"lines"`
+	Reason string              `json:"reason,omitempty"`
+}
+
 type BookingResponse struct {
 	BookingID     string          `json:"booking_id"`
 	Status        BookingStatus   `json:"status"`
 	ReservedUntil time.Time       `json:"reserved_until"`
-	TotalAmount   float64         `json:"total_amount"`
+	Subtotal      money.Amount    `json:"subtotal"`
+	Discount      money.Amount    `json:"discount"`
+	Promo         *BookingPromo   `json:"promo,omitempty"`
+	ServiceFee    money.Amount    `json:"service_fee"`
+	TotalAmount   money.Amount    `json:"total_amount"`
+	Currency      string          `json:"currency"`
+	Fees          []AppliedFee    `json:"fees"`
 	Tickets       []BookingTicket `json:"tickets"`
 }
 
+type BookingListRequest struct {
+	UserID    string
+	EventID   string
+	Status    BookingStatus
+	Cursor    string
+	Limit     int
+	Ascending bool
+}
+
+type BookingList struct {
+	Items      []Booking `json:"items"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
 type Event struct {
 	ID          primitive.ObjectID `json:"id" bson:"_id"`
 	Name        string             `json:"name" bson:"name"`
+	OrganizerID primitive.ObjectID `json:"organizer_id,omitempty" bson:"organizer_id,omitempty"`
 	Date        time.Time          `json:"date" bson:"date"`
-	TicketTypes []TicketType       `json:"ticket_type" bson:"ticket_type"`
+	TicketTypes []TicketType       `json:"ticket_type" bson:"ticket_types"`
+	// Currency валюта, в которой заданы TicketType.Price. Пустая у мероприятий, созданных до мультивалютности
+	Currency string `json:"currency" bson:"currency,omitempty"`
+
+	// ManualCapture включает двухстадийную оплату: деньги сначала блокируются и списываются
+	// только после проверки брони
+	ManualCapture bool `json:"manual_capture" bson:"manual_capture"`
+
+	Fiscal *FiscalSettings `json:"fiscal,omitempty" bson:"fiscal,omitempty"`
+	Hold   *HoldPolicy     `json:"hold,omitempty" bson:"hold,omitempty"`
 }
 
 type TicketType struct {
-	ID        primitive.ObjectID `json:"id" bson:"_id"`
-	Name      string             `json:"name" bson:"name"`
-	Quantity  int                `json:"quantity" bson:"quantity"`
-	SoldCount int                `json:"sold_count" bson:"sold_count"`
-	Price     float64            `json:"price" bson:"price"`
+	ID            primitive.ObjectID `json:"id" bson:"_id"`
+	Name          string             `json:"name" bson:"name"`
+	Quantity      int                `json:"quantity" bson:"quantity"`
+	SoldCount     int                `json:"sold_count" bson:"sold_count"`
+	ReservedCount int                `json:"reserved_count" bson:"reserved_count"`
+	Price         money.Amount       `json:"price" bson:"price"`
+	// Prices цены в других валютах, если билеты продаются не только в валюте мероприятия
+	Prices map[string]money.Amount `json:"prices,omitempty" bson:"prices,omitempty"`
+	// PriceTiers расписание цен (ранняя продажа, последний момент). Если ни одна ступень не подходит, действует Price
+	PriceTiers []PriceTier `json:"price_tiers,omitempty" bson:"price_tiers,omitempty"`
+
+	AssignedSeating bool `json:"assigned_seating" bson:"assigned_seating"`
+}
+
+func (e *Event) BaseCurrency() string {
+	if e.Currency == "" {
+		return money.RUB
+	}
+	return e.Currency
+}
+
+// PriceIn возвращает цену билета в валюте currency, false если в этой валюте тип билета не продаётся
+func (tt *TicketType) PriceIn(currency, baseCurrency string) (money.Amount, bool) {
+	if currency == baseCurrency {
+		return tt.Price, true
+	}
+	price, ok := tt.Prices[currency]
+	return price, ok
+}
+
+// ActiveQuantity число билетов строки, которые не возвращены
+func (bt *BookingTicket) ActiveQuantity() int {
+	return bt.Quantity - bt.RefundedQuantity
+}
+
+// ActiveSeats места строки, которые не возвращены
+func (bt *BookingTicket) ActiveSeats() []Seat {
+	refunded := make(map[string]bool, len(bt.RefundedSeats))
+	for _, id := range bt.RefundedSeats {
+		refunded[id] = true
+	}
+	var seats []Seat
+	for _, seat := range bt.Seats {
+		if !refunded[seat.SeatID] {
+			seats = append(seats, seat)
+		}
+	}
+	return seats
+}
+
+func (tt *TicketType) Available() int {
+	return tt.Quantity - tt.SoldCount - tt.ReservedCount
+}
+
+type TicketInventory struct {
+	TicketTypeID  primitive.ObjectID `json:"ticket_type_id"`
+	Name          string             `json:"name"`
+	Quantity      int                `json:"quantity"`
+	SoldCount     int                `json:"sold_count"`
+	ReservedCount int                `json:"reserved_count"`
+	Available     int                `json:"available"`
+	// Prices цены во всех валютах, в которых продаётся тип билета
+	Prices    map[string]money.Amount `json:"prices"`
+	PriceTier string                  `json:"price_tier,omitempty"`
+}
+
+type SeatStatus string
+
+const (
+	SeatStatusFree     SeatStatus = "free"
+	SeatStatusReserved SeatStatus = "reserved"
+	SeatStatusSold     SeatStatus = "sold"
+)
+
+type EventSeat struct {
+	ID           primitive.ObjectID `json:"id" bson:"_id"`
+	EventID      primitive.ObjectID `json:"event_id" bson:"event_id"`
+	TicketTypeID primitive.ObjectID `json:"ticket_type_id" bson:"ticket_type_id"`
+	Sector       string             `json:"sector" bson:"sector"`
+	Row          string             `json:"row" bson:"row"`
+	Number       string             `json:"number" bson:"number"`
+	Status       SeatStatus         `json:"status" bson:"status"`
+	BookingID    primitive.ObjectID `json:"booking_id,omitempty" bson:"booking_id,omitempty"`
+	UpdatedAt    time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+func (s *EventSeat) Seat() Seat {
+	return Seat{
+		Sector: s.Sector,
+		Row:    s.Row,
+		Number: s.Number,
+		SeatID: s.ID.Hex(),
+	}
 }
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"time"
+
+	"github.com/DrummDaddy/Booking_service/internal/money"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ModificationStatus string
+
+const (
+	// ModificationStatusPending новые билеты зарезервированы и ждут доплаты
+	ModificationStatusPending   ModificationStatus = "pending"
+	ModificationStatusCompleted ModificationStatus = "completed"
+	ModificationStatusCanceled  ModificationStatus = "canceled"
+)
+
+// BookingModification обмен билетов строки брони на другой тип билета или другие места.
+// Изменения брони хранятся в ней же, ID брони при обмене не меняется.
+type BookingModification struct {
+	ID     primitive.ObjectID `json:"id" bson:"id"`
+	Status ModificationStatus `json:"status" bson:"status"`
+
+	// TicketIndex, Quantity и Seats сдаваемые билеты строки брони
+	TicketIndex  int                `json:"ticket_index" bson:"ticket_index"`
+	TicketTypeID primitive.ObjectID `json:"ticket_type_id" bson:"ticket_type_id"`
+	Quantity     int                `json:"quantity" bson:"quantity"`
+	Seats        []string           `json:"seats,omitempty" bson:"seats,omitempty"`
+	// Tickets новые строки брони, цены по ступеням на момент резерва
+	Tickets []BookingTicket `json:"tickets" bson:"tickets"`
+
+	// PriceDifference изменение total_amount: больше нуля доплата, меньше нуля возврат разницы
+	PriceDifference  money.Amount `json:"price_difference" bson:"price_difference"`
+	SubtotalChange   money.Amount `json:"subtotal_change" bson:"subtotal_change"`
+	DiscountChange   money.Amount `json:"discount_change" bson:"discount_change"`
+	ServiceFeeChange money.Amount `json:"service_fee_change" bson:"service_fee_change"`
+	// Fees расшифровка сбора после обмена
+	Fees []AppliedFee `json:"fees,omitempty" bson:"fees,omitempty"`
+
+	// PaymentID платёж доплаты у провайдера, RefundIDs возвраты разницы
+	PaymentID string               `json:"payment_id,omitempty" bson:"payment_id,omitempty"`
+	RefundIDs []primitive.ObjectID `json:"refund_ids,omitempty" bson:"refund_ids,omitempty"`
+	// ConfirmationURL страница оплаты доплаты, только в ответе на создание обмена
+	ConfirmationURL string `json:"confirmation_url,omitempty" bson:"-"`
+	// ExpiresAt срок ожидания доплаты, после него обмен отменяется
+	ExpiresAt time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+
+	Actor     string    `json:"actor" bson:"actor"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// ModificationRequest обмен Quantity билетов строки TicketIndex на тип TicketID.
+// Для смены мест тот же тип указывается с новыми местами.
+type ModificationRequest struct {
+	TicketIndex int `json:"ticket_index"`
+	Quantity    int `json:"quantity"`
+	// Seats сдаваемые места, по умолчанию последние места строки
+	Seats    []string `json:"seats,omitempty"`
+	TicketID string   `json:"ticket_id"`
+	NewSeats []Seat   `json:"new_seats,omitempty"`
+
+	// ReturnURL, Email и Phone нужны для доплаты по оплаченной брони
+	ReturnURL string `json:"return_url,omitempty"`
+	Email     string `json:"email,omitempty"`
+	Phone     string `json:"phone,omitempty"`
+}
+
+// PendingModification обмен, который ждёт доплаты, nil если такого нет
+func (b *Booking) PendingModification() *BookingModification {
+	for i := range b.Modifications {
+		if b.Modifications[i].Status == ModificationStatusPending {
+			return &b.Modifications[i]
+		}
+	}
+	return nil
+}
+
+// ExpiredModifications обмены, которые не дождались доплаты к моменту now
+func (b *Booking) ExpiredModifications(now time.Time) []BookingModification {
+	var expired []BookingModification
+	for _, modification := range b.Modifications {
+		if modification.Status == ModificationStatusPending && !modification.ExpiresAt.IsZero() && modification.ExpiresAt.Before(now) {
+			expired = append(expired, modification)
+		}
+	}
+	return expired
+}
+
+func (b *Booking) Modification(id primitive.ObjectID) *BookingModification {
+	for i := range b.Modifications {
+		if b.Modifications[i].ID == id {
+			return &b.Modifications[i]
+		}
+	}
+	return nil
+}
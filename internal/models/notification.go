@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type NotificationStatus string
+
+const (
+	NotificationStatusReceived   NotificationStatus = "received"
+	NotificationStatusProcessing NotificationStatus = "processing"
+	NotificationStatusProcessed  NotificationStatus = "processed"
+	NotificationStatusFailed     NotificationStatus = "failed"
+)
+
+// PaymentNotification сырое уведомление платёжного провайдера. EventKey уникален,
+// по нему повторно присланные уведомления не обрабатываются второй раз.
+type PaymentNotification struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	EventKey    string             `json:"event_key" bson:"event_key"`
+	Event       string             `json:"event" bson:"event"`
+	ObjectID    string             `json:"object_id" bson:"object_id"`
+	Body        string             `json:"body" bson:"body"`
+	RemoteAddr  string             `json:"remote_addr" bson:"remote_addr"`
+	Status      NotificationStatus `json:"status" bson:"status"`
+	Attempts    int                `json:"attempts" bson:"attempts"`
+	Error       string             `json:"error,omitempty" bson:"error,omitempty"`
+	ReceivedAt  time.Time          `json:"received_at" bson:"received_at"`
+	UpdatedAt   time.Time          `json:"updated_at" bson:"updated_at"`
+	ProcessedAt *time.Time         `json:"processed_at,omitempty" bson:"processed_at,omitempty"`
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"github.com/DrummDaddy/Booking_service/internal/money"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type PaymentStatus string
+
+const (
+	PaymentStatusPending           PaymentStatus = "pending"
+	PaymentStatusWaitingForCapture PaymentStatus = "waiting_for_capture"
+	PaymentStatusSucceeded         PaymentStatus = "succeeded"
+	PaymentStatusCanceled          PaymentStatus = "canceled"
+	PaymentStatusFailed            PaymentStatus = "failed"
+)
+
+type Payment struct {
+	ID                primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	BookingID         primitive.ObjectID `json:"booking_id" bson:"booking_id"`
+	ProviderPaymentID string             `json:"provider_payment_id,omitempty" bson:"provider_payment_id,omitempty"`
+	Amount            money.Amount       `json:"amount" bson:"amount"`
+	Currency          string             `json:"currency" bson:"currency"`
+	Status            PaymentStatus      `json:"status" bson:"status"`
+	TwoStage          bool               `json:"two_stage" bson:"two_stage"`
+	ConfirmationURL   string             `json:"confirmation_url,omitempty" bson:"confirmation_url,omitempty"`
+	Error             string             `json:"error,omitempty" bson:"error,omitempty"`
+	// ModificationID платёж доплаты за обмен билетов
+	ModificationID primitive.ObjectID `json:"modification_id,omitempty" bson:"modification_id,omitempty"`
+
+	// Receipt чек, отправленный провайдеру, хранится для сверки с фискальными данными
+	Receipt *Receipt `json:"receipt,omitempty" bson:"receipt,omitempty"`
+
+	ProviderResponses []ProviderResponse `json:"-" bson:"provider_responses,omitempty"`
+
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+type ProviderResponse struct {
+	Operation  string    `json:"operation" bson:"operation"`
+	Body       string    `json:"body" bson:"body"`
+	ReceivedAt time.Time `json:"received_at" bson:"received_at"`
+}
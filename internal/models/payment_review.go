@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ReviewStatus string
+
+const (
+	ReviewStatusOpen     ReviewStatus = "open"
+	ReviewStatusResolved ReviewStatus = "resolved"
+)
+
+// PaymentReview расхождение между провайдером и бронью, которое нужно разобрать вручную,
+// например деньги списаны, а бронь уже истекла или отменена
+type PaymentReview struct {
+	ID                primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	PaymentID         primitive.ObjectID `json:"payment_id" bson:"payment_id"`
+	ProviderPaymentID string             `json:"provider_payment_id" bson:"provider_payment_id"`
+	BookingID         primitive.ObjectID `json:"booking_id" bson:"booking_id"`
+	PaymentStatus     PaymentStatus      `json:"payment_status" bson:"payment_status"`
+	BookingStatus     BookingStatus      `json:"booking_status" bson:"booking_status"`
+	Reason            string             `json:"reason" bson:"reason"`
+	Status            ReviewStatus       `json:"status" bson:"status"`
+	CreatedAt         time.Time          `json:"created_at" bson:"created_at"`
+}
+
+type ReconcileReport struct {
+	Checked int `json:"checked"`
+	Changed int `json:"changed"`
+	Failed  int `json:"failed"`
+}
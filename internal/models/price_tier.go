@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"github.com/DrummDaddy/Booking_service/internal/money"
+)
+
+// PriceTier ступень цены типа билета. Ступень действует в окне дат ValidFrom..ValidUntil
+// и пока продано и зарезервировано меньше UpTo билетов (UpTo считается от начала продаж, 0 без ограничения).
+type PriceTier struct {
+	Name       string                  `json:"name" bson:"name"`
+	Price      money.Amount            `json:"price" bson:"price"`
+	Prices     map[string]money.Amount `json:"prices,omitempty" bson:"prices,omitempty"`
+	ValidFrom  time.Time               `json:"valid_from,omitempty" bson:"valid_from,omitempty"`
+	ValidUntil time.Time               `json:"valid_until,omitempty" bson:"valid_until,omitempty"`
+	UpTo       int                     `json:"up_to,omitempty" bson:"up_to,omitempty"`
+}
+
+func (pt *PriceTier) activeAt(position int, at time.Time) bool {
+	if !pt.ValidFrom.IsZero() && at.Before(pt.ValidFrom) {
+		return false
+	}
+	if !pt.ValidUntil.IsZero() && !at.Before(pt.ValidUntil) {
+		return false
+	}
+	return pt.UpTo == 0 || position < pt.UpTo
+}
+
+// TierAt возвращает первую подходящую ступень для билета с порядковым номером position (с нуля),
+// nil если ни одна не подходит и действует TicketType.Price
+func (tt *TicketType) TierAt(position int, at time.Time) *PriceTier {
+	for i := range tt.PriceTiers {
+		if tt.PriceTiers[i].activeAt(position, at) {
+			return &tt.PriceTiers[i]
+		}
+	}
+	return nil
+}
+
+// PriceAt цена билета с порядковым номером position в валюте currency с учётом ступеней
+func (tt *TicketType) PriceAt(currency, baseCurrency string, position int, at time.Time) (money.Amount, string, bool) {
+	tier := tt.TierAt(position, at)
+	if tier == nil {
+		price, ok := tt.PriceIn(currency, baseCurrency)
+		return price, "", ok
+	}
+	if currency == baseCurrency {
+		return tier.Price, tier.Name, true
+	}
+	price, ok := tier.Prices[currency]
+	return price, tier.Name, ok
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTierAt(t *testing.T) {
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	ticketType := &TicketType{
+		Price: 300000,
+		PriceTiers: []PriceTier{
+			{Name: "early", Price: 100000, ValidUntil: now, UpTo: 10},
+			{Name: "first_hundred", Price: 200000, UpTo: 100},
+			{Name: "last_minute", Price: 400000, ValidFrom: now.Add(24 * time.Hour)},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		position int
+		at       time.Time
+		want     string
+	}{
+		{name: "early bird", position: 0, at: now.Add(-time.Hour), want: "early"},
+		{name: "early bird sold out", position: 10, at: now.Add(-time.Hour), want: "first_hundred"},
+		// ValidUntil не включается в окно ступени
+		{name: "early bird window closed", position: 0, at: now, want: "first_hundred"},
+		{name: "no tier left", position: 100, at: now, want: ""},
+		{name: "last minute", position: 150, at: now.Add(24 * time.Hour), want: "last_minute"},
+		{name: "first matching tier wins", position: 5, at: now.Add(48 * time.Hour), want: "first_hundred"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			if tier := ticketType.TierAt(tt.position, tt.at); tier != nil {
+				got = tier.Name
+			}
+			if got != tt.want {
+				t.Errorf("TierAt(%d) = %q, want %q", tt.position, got, tt.want)
+			}
+		})
+	}
+}
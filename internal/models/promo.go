@@ -0,0 +1,82 @@
+package models
+
+import (
+	"time"
+
+	"github.com/DrummDaddy/Booking_service/internal/money"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type PromoType string
+
+const (
+	// PromoPercent скидка в процентах от стоимости билетов (BasisPoints, 1000 = 10%)
+	PromoPercent PromoType = "percent"
+	// PromoFixed фиксированная скидка из Amounts в валюте брони
+	PromoFixed PromoType = "fixed"
+	// PromoBuyNGetM из каждых BuyQuantity+FreeQuantity билетов FreeQuantity самых дешёвых бесплатно
+	PromoBuyNGetM PromoType = "buy_n_get_m"
+	// PromoFreeTicketType FreeQuantity билетов типов из TicketTypeIDs бесплатно
+	PromoFreeTicketType PromoType = "free_ticket_type"
+)
+
+type PromoCode struct {
+	ID          primitive.ObjectID      `json:"id" bson:"_id,omitempty"`
+	Code        string                  `json:"code" bson:"code"`
+	Type        PromoType               `json:"type" bson:"type"`
+	BasisPoints int64                   `json:"basis_points,omitempty" bson:"basis_points,omitempty"`
+	Amounts     map[string]money.Amount `json:"amounts,omitempty" bson:"amounts,omitempty"`
+
+	BuyQuantity  int `json:"buy_quantity,omitempty" bson:"buy_quantity,omitempty"`
+	FreeQuantity int `json:"free_quantity,omitempty" bson:"free_quantity,omitempty"`
+
+	// Пустые списки означают все мероприятия и все типы билетов
+	EventIDs      []primitive.ObjectID `json:"event_ids,omitempty" bson:"event_ids,omitempty"`
+	TicketTypeIDs []primitive.ObjectID `json:"ticket_type_ids,omitempty" bson:"ticket_type_ids,omitempty"`
+
+	ValidFrom  time.Time `json:"valid_from,omitempty" bson:"valid_from,omitempty"`
+	ValidUntil time.Time `json:"valid_until,omitempty" bson:"valid_until,omitempty"`
+
+	// MaxRedemptions и MaxPerUser ограничивают число действующих применений, 0 без ограничений.
+	// Redemptions уменьшается, когда бронь с промокодом истекает или отменяется.
+	MaxRedemptions int `json:"max_redemptions" bson:"max_redemptions"`
+	MaxPerUser     int `json:"max_per_user" bson:"max_per_user"`
+	Redemptions    int `json:"redemptions" bson:"redemptions"`
+
+	Active    bool      `json:"active" bson:"active"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+func (p *PromoCode) AppliesToEvent(eventID primitive.ObjectID) bool {
+	return len(p.EventIDs) == 0 || containsID(p.EventIDs, eventID)
+}
+
+func (p *PromoCode) AppliesToTicketType(ticketTypeID primitive.ObjectID) bool {
+	return len(p.TicketTypeIDs) == 0 || containsID(p.TicketTypeIDs, ticketTypeID)
+}
+
+func (p *PromoCode) ValidAt(t time.Time) bool {
+	if !p.ValidFrom.IsZero() && t.Before(p.ValidFrom) {
+		return false
+	}
+	if !p.ValidUntil.IsZero() && !t.Before(p.ValidUntil) {
+		return false
+	}
+	return true
+}
+
+// BookingPromo промокод, применённый к брони. Нужен, чтобы вернуть применение при отмене или истечении брони.
+type BookingPromo struct {
+	PromoID primitive.ObjectID `json:"promo_id" bson:"promo_id"`
+	Code    string             `json:"code" bson:"code"`
+	Type    PromoType          `json:"type" bson:"type"`
+}
+
+func containsID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"github.com/DrummDaddy/Booking_service/internal/money"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Quote расчёт корзины без резерва. Цены и наличие актуальны на момент QuotedAt
+// и могут измениться к созданию брони.
+type Quote struct {
+	EventID      string               `json:"event_id"`
+	Currency     string               `json:"currency"`
+	Tickets      []BookingTicket      `json:"tickets"`
+	Subtotal     money.Amount         `json:"subtotal"`
+	Discount     money.Amount         `json:"discount"`
+	Promo        *BookingPromo        `json:"promo,omitempty"`
+	ServiceFee   money.Amount         `json:"service_fee"`
+	Fees         []AppliedFee         `json:"fees"`
+	TotalAmount  money.Amount         `json:"total_amount"`
+	Available    bool                 `json:"available"`
+	Availability []TicketAvailability `json:"availability"`
+	QuotedAt     time.Time            `json:"quoted_at"`
+}
+
+type TicketAvailability struct {
+	TicketTypeID primitive.ObjectID `json:"ticket_type_id"`
+	Name         string             `json:"name"`
+	Requested    int                `json:"requested"`
+	Available    int                `json:"available"`
+	// UnavailableSeats выбранные места, которые уже заняты
+	UnavailableSeats []string `json:"unavailable_seats,omitempty"`
+}
@@ -0,0 +1,73 @@
+package models
+
+import "github.com/DrummDaddy/Booking_service/internal/money"
+
+// Признаки предмета и способа расчёта и коды НДС в значениях API YooKassa
+const (
+	PaymentSubjectService = "service"
+
+	PaymentModeFullPayment    = "full_payment"
+	PaymentModeFullPrepayment = "full_prepayment"
+
+	VatCodeNone = 1
+)
+
+// Receipt данные чека по 54-ФЗ, передаются провайдеру вместе с платежом и возвратом
+type Receipt struct {
+	Customer      ReceiptCustomer `json:"customer" bson:"customer"`
+	Items         []ReceiptItem   `json:"items" bson:"items"`
+	TaxSystemCode int             `json:"tax_system_code,omitempty" bson:"tax_system_code,omitempty"`
+}
+
+type ReceiptCustomer struct {
+	Email string `json:"email,omitempty" bson:"email,omitempty"`
+	Phone string `json:"phone,omitempty" bson:"phone,omitempty"`
+}
+
+// ReceiptItem позиция чека. Amount цена за единицу, сумма позиции Amount * Quantity.
+type ReceiptItem struct {
+	Description    string      `json:"description" bson:"description"`
+	Quantity       int         `json:"quantity" bson:"quantity"`
+	Amount         money.Money `json:"amount" bson:"amount"`
+	VatCode        int         `json:"vat_code" bson:"vat_code"`
+	PaymentSubject string      `json:"payment_subject" bson:"payment_subject"`
+	PaymentMode    string      `json:"payment_mode" bson:"payment_mode"`
+}
+
+func (r *Receipt) Total() money.Amount {
+	var total money.Amount
+	for _, item := range r.Items {
+		total += item.Amount.Amount.Mul(item.Quantity)
+	}
+	return total
+}
+
+// FiscalSettings параметры чека мероприятия. Незаполненные поля берутся по умолчанию:
+// без НДС, предмет расчёта "услуга", полная предоплата (билет оплачивается до мероприятия).
+type FiscalSettings struct {
+	VatCode        int    `json:"vat_code,omitempty" bson:"vat_code,omitempty"`
+	FeeVatCode     int    `json:"fee_vat_code,omitempty" bson:"fee_vat_code,omitempty"`
+	PaymentSubject string `json:"payment_subject,omitempty" bson:"payment_subject,omitempty"`
+	PaymentMode    string `json:"payment_mode,omitempty" bson:"payment_mode,omitempty"`
+	TaxSystemCode  int    `json:"tax_system_code,omitempty" bson:"tax_system_code,omitempty"`
+}
+
+func (e *Event) FiscalSettings() FiscalSettings {
+	settings := FiscalSettings{}
+	if e.Fiscal != nil {
+		settings = *e.Fiscal
+	}
+	if settings.VatCode == 0 {
+		settings.VatCode = VatCodeNone
+	}
+	if settings.FeeVatCode == 0 {
+		settings.FeeVatCode = settings.VatCode
+	}
+	if settings.PaymentSubject == "" {
+		settings.PaymentSubject = PaymentSubjectService
+	}
+	if settings.PaymentMode == "" {
+		settings.PaymentMode = PaymentModeFullPrepayment
+	}
+	return settings
+}
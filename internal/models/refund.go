@@ -0,0 +1,76 @@
+package models
+
+import (
+	"time"
+
+	"github.com/DrummDaddy/Booking_service/internal/money"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type RefundStatus string
+
+const (
+	RefundStatusPending   RefundStatus = "pending"
+	RefundStatusSucceeded RefundStatus = "succeeded"
+	RefundStatusCanceled  RefundStatus = "canceled"
+	// RefundStatusFailed провайдер не принял запрос на возврат
+	RefundStatusFailed RefundStatus = "failed"
+)
+
+// Refund возврат по брони. Полный возврат, возврат отдельных билетов или произвольной суммы.
+type Refund struct {
+	ID               primitive.ObjectID `json:"id" bson:"_id"`
+	BookingID        primitive.ObjectID `json:"booking_id" bson:"booking_id"`
+	PaymentID        string             `json:"payment_id" bson:"payment_id"`
+	ProviderRefundID string             `json:"provider_refund_id,omitempty" bson:"provider_refund_id,omitempty"`
+	// ModificationID возврат разницы при обмене на более дешёвые билеты, он не входит в refunded_amount брони
+	ModificationID primitive.ObjectID `json:"modification_id,omitempty" bson:"modification_id,omitempty"`
+	Amount         money.Amount       `json:"amount" bson:"amount"`
+	// FeeAmount часть Amount, которая возвращает сервисный сбор
+	FeeAmount money.Amount `json:"fee_amount" bson:"fee_amount"`
+	Currency  string       `json:"currency" bson:"currency"`
+	Status    RefundStatus `json:"status" bson:"status"`
+	// Lines возвращаемые билеты. Пусто у возврата произвольной суммы: он не меняет остатки билетов.
+	Lines     []RefundLine `json:"lines,omitempty" bson:"lines,omitempty"`
+	Reason    string       `json:"reason,omitempty" bson:"reason,omitempty"`
+	Actor     string       `json:"actor" bson:"actor"`
+	Receipt   *Receipt     `json:"receipt,omitempty" bson:"receipt,omitempty"`
+	Error     string       `json:"error,omitempty" bson:"error,omitempty"`
+	CreatedAt time.Time    `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at" bson:"updated_at"`
+}
+
+type RefundLine struct {
+	// TicketIndex номер строки в Booking.Tickets
+	TicketIndex  int                `json:"ticket_index" bson:"ticket_index"`
+	TicketTypeID primitive.ObjectID `json:"ticket_type_id" bson:"ticket_type_id"`
+	Quantity     int                `json:"quantity" bson:"quantity"`
+	Seats        []string           `json:"seats,omitempty" bson:"seats,omitempty"`
+	Amount       money.Amount       `json:"amount" bson:"amount"`
+}
+
+// RefundRequest запрос на возврат. Без Lines и Amount возвращается всё, что ещё не возвращено.
+type RefundRequest struct {
+	Lines  []TicketLineRequest `json:"lines,omitempty"`
+	Amount money.Amount        `json:"amount,omitempty"`
+	// ExcludeFee не возвращать сервисный сбор
+	ExcludeFee bool   `json:"exclude_fee"`
+	Reason     string `json:"reason"`
+}
+
+// Rejected провайдер отклонил или отменил возврат, деньги не вернутся
+func (r *Refund) Rejected() bool {
+	return r.Status == RefundStatusCanceled || r.Status == RefundStatusFailed
+}
+
+// HasRefundStatus есть ли среди возвратов хотя бы один в одном из статусов
+func HasRefundStatus(refunds []Refund, statuses ...RefundStatus) bool {
+	for _, refund := range refunds {
+		for _, status := range statuses {
+			if refund.Status == status {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,226 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// Все поддерживаемые валюты (RUB, USD, EUR, KZT...) делятся на 100 минимальных единиц
+const minorUnits = 100
+
+const (
+	RUB = "RUB"
+	USD = "USD"
+	EUR = "EUR"
+	KZT = "KZT"
+)
+
+var ErrCurrencyMismatch = errors.New("money: currency mismatch")
+
+var supportedCurrencies = map[string]bool{RUB: true, USD: true, EUR: true, KZT: true}
+
+func IsSupported(currency string) bool {
+	return supportedCurrencies[currency]
+}
+
+type RoundingMode int
+
+const (
+	// RoundHalfUp округляет до ближайшей копейки, половину от нуля: 0.005 -> 0.01
+	RoundHalfUp RoundingMode = iota
+	// RoundDown отбрасывает доли копейки
+	RoundDown
+	// RoundUp добавляет копейку, если есть доли
+	RoundUp
+)
+
+// Amount сумма в минимальных единицах валюты (копейках). В JSON и BSON хранится десятичным числом
+// в основных единицах (123.45), как и прежние поля float64, поэтому формат API и документов не меняется.
+type Amount int64
+
+func Minor(units int64) Amount {
+	return Amount(units)
+}
+
+// Parse разбирает десятичную запись ("123.45", "1e3") без потери точности, доли копейки округляются RoundHalfUp
+func Parse(s string) (Amount, error) {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, fmt.Errorf("money: invalid amount %q", s)
+	}
+	return fromRat(r.Mul(r, big.NewRat(minorUnits, 1)), RoundHalfUp)
+}
+
+// FromFloat переводит сумму из float64 по её кратчайшей десятичной записи: 1.005 -> 1.01
+func FromFloat(f float64) (Amount, error) {
+	return Parse(strconv.FormatFloat(f, 'g', -1, 64))
+}
+
+func (a Amount) Mul(n int) Amount {
+	return a * Amount(n)
+}
+
+// Percent возвращает basisPoints/10000 от суммы (1000 = 10%) с явным правилом округления
+func (a Amount) Percent(basisPoints int64, mode RoundingMode) Amount {
+	r := big.NewRat(int64(a)*basisPoints, 10000)
+	amount, _ := fromRat(r, mode)
+	return amount
+}
+
+// MulRat возвращает сумму, умноженную на num/den, с явным правилом округления
+func (a Amount) MulRat(num, den int64, mode RoundingMode) Amount {
+	amount, _ := fromRat(big.NewRat(int64(a)*num, den), mode)
+	return amount
+}
+
+func (a Amount) Float64() float64 {
+	return float64(a) / minorUnits
+}
+
+func (a Amount) String() string {
+	sign := ""
+	v := int64(a)
+	if v < 0 {
+		sign, v = "-", -v
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, v/minorUnits, v%minorUnits)
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *Amount) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		var n json.Number
+		if err := json.Unmarshal(b, &n); err != nil {
+			return fmt.Errorf("money: invalid amount %s", b)
+		}
+		s = n.String()
+	}
+
+	amount, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*a = amount
+	return nil
+}
+
+func (a Amount) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	return bsontype.Double, bsoncore.AppendDouble(nil, a.Float64()), nil
+}
+
+func (a *Amount) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	value := bsoncore.Value{Type: t, Data: data}
+
+	var (
+		amount Amount
+		err    error
+	)
+	switch t {
+	case bsontype.Double:
+		amount, err = FromFloat(value.Double())
+	case bsontype.Int32:
+		amount = Amount(value.Int32()) * minorUnits
+	case bsontype.Int64:
+		amount = Amount(value.Int64()) * minorUnits
+	case bsontype.Decimal128:
+		amount, err = Parse(value.Decimal128().String())
+	case bsontype.Null:
+		amount = 0
+	default:
+		return fmt.Errorf("money: cannot decode %s into amount", t)
+	}
+	if err != nil {
+		return err
+	}
+
+	*a = amount
+	return nil
+}
+
+func fromRat(r *big.Rat, mode RoundingMode) (Amount, error) {
+	num, den := new(big.Int).Set(r.Num()), r.Denom()
+
+	neg := num.Sign() < 0
+	num.Abs(num)
+
+	quo, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if rem.Sign() != 0 {
+		switch mode {
+		case RoundHalfUp:
+			if new(big.Int).Mul(rem, big.NewInt(2)).Cmp(den) >= 0 {
+				quo.Add(quo, big.NewInt(1))
+			}
+		case RoundUp:
+			quo.Add(quo, big.NewInt(1))
+		}
+	}
+	if !quo.IsInt64() {
+		return 0, errors.New("money: amount out of range")
+	}
+
+	v := quo.Int64()
+	if neg {
+		v = -v
+	}
+	return Amount(v), nil
+}
+
+// Money сумма вместе с валютой. В JSON передаётся в формате платёжных API: {"value": "123.45", "currency": "RUB"}.
+type Money struct {
+	Amount   Amount `json:"-" bson:"value"`
+	Currency string `json:"currency" bson:"currency"`
+}
+
+func New(amount Amount, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, ErrCurrencyMismatch
+	}
+	return Money{Amount: m.Amount + other.Amount, Currency: m.Currency}, nil
+}
+
+func (m Money) Sub(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, ErrCurrencyMismatch
+	}
+	return Money{Amount: m.Amount - other.Amount, Currency: m.Currency}, nil
+}
+
+func (m Money) String() string {
+	return m.Amount.String() + " " + m.Currency
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Value    string `json:"value"`
+		Currency string `json:"currency"`
+	}{
+		Value:    m.Amount.String(),
+		Currency: m.Currency,
+	})
+}
+
+func (m *Money) UnmarshalJSON(b []byte) error {
+	var payload struct {
+		Value    Amount `json:"value"`
+		Currency string `json:"currency"`
+	}
+	if err := json.Unmarshal(b, &payload); err != nil {
+		return err
+	}
+	m.Amount, m.Currency = payload.Value, payload.Currency
+	return nil
+}
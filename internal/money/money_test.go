@@ -0,0 +1,151 @@
+package money
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Amount
+	}{
+		{"123.45", 12345},
+		{"0.005", 1},
+		{"0.004", 0},
+		{"-1.005", -101},
+		{"1e3", 100000},
+		{"50", 5000},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.in, err)
+		}
+		if got != tt.want {
+			t.Errorf("Parse(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+
+	if _, err := Parse("abc"); err == nil {
+		t.Error("Parse(abc): expected error")
+	}
+}
+
+func TestFromFloat(t *testing.T) {
+	tests := []struct {
+		in   float64
+		want Amount
+	}{
+		{1.005, 101},
+		{0.1 + 0.2, 30},
+		{99.99, 9999},
+	}
+	for _, tt := range tests {
+		got, err := FromFloat(tt.in)
+		if err != nil {
+			t.Fatalf("FromFloat(%v): %v", tt.in, err)
+		}
+		if got != tt.want {
+			t.Errorf("FromFloat(%v) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMulRat(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   Amount
+		num, den int64
+		mode     RoundingMode
+		want     Amount
+	}{
+		{"exact", 30000, 1, 3, RoundDown, 10000},
+		{"down", 10000, 1, 3, RoundDown, 3333},
+		{"up", 10000, 1, 3, RoundUp, 3334},
+		{"half up below half", 10000, 1, 3, RoundHalfUp, 3333},
+		{"half up at half", 5, 1, 2, RoundHalfUp, 3},
+		{"negative half up", -5, 1, 2, RoundHalfUp, -3},
+		{"negative down", -10000, 1, 3, RoundDown, -3333},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.amount.MulRat(tt.num, tt.den, tt.mode); got != tt.want {
+				t.Errorf("MulRat = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPercent(t *testing.T) {
+	tests := []struct {
+		amount      Amount
+		basisPoints int64
+		mode        RoundingMode
+		want        Amount
+	}{
+		{100000, 1000, RoundHalfUp, 10000},
+		{12345, 1000, RoundHalfUp, 1235},
+		{12345, 1000, RoundDown, 1234},
+		{12341, 1000, RoundUp, 1235},
+	}
+	for _, tt := range tests {
+		if got := tt.amount.Percent(tt.basisPoints, tt.mode); got != tt.want {
+			t.Errorf("%d.Percent(%d) = %d, want %d", tt.amount, tt.basisPoints, got, tt.want)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		in   Amount
+		want string
+	}{
+		{0, "0.00"},
+		{5, "0.05"},
+		{12345, "123.45"},
+		{-101, "-1.01"},
+	}
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("Amount(%d).String() = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestAmountJSON(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Amount
+	}{
+		{`123.45`, 12345},
+		{`"123.45"`, 12345},
+		{`1.005`, 101},
+	}
+	for _, tt := range tests {
+		var got Amount
+		if err := json.Unmarshal([]byte(tt.in), &got); err != nil {
+			t.Fatalf("unmarshal %s: %v", tt.in, err)
+		}
+		if got != tt.want {
+			t.Errorf("unmarshal %s = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+
+	raw, err := json.Marshal(New(12345, RUB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != `{"value":"123.45","currency":"RUB"}` {
+		t.Errorf("marshal Money = %s", raw)
+	}
+}
+
+func TestMoneyCurrencyMismatch(t *testing.T) {
+	if _, err := New(100, RUB).Add(New(100, USD)); err != ErrCurrencyMismatch {
+		t.Errorf("Add: got %v, want ErrCurrencyMismatch", err)
+	}
+	if _, err := New(100, RUB).Sub(New(100, EUR)); err != ErrCurrencyMismatch {
+		t.Errorf("Sub: got %v, want ErrCurrencyMismatch", err)
+	}
+}
@@ -2,13 +2,24 @@ package repositories
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/DrummDaddy/Booking_service/internal/models"
+	"github.com/DrummDaddy/Booking_service/internal/money"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrStatusChanged = errors.New("booking status was changed concurrently")
+	ErrInvalidCursor = errors.New("invalid cursor")
 )
 
 type BookingRepository struct {
@@ -45,16 +56,36 @@ func (br *BookingRepository) FindByIDAndUser(ctx context.Context, id, userID pri
 	return &booking, nil
 }
 
-func (br *BookingRepository) UpdateStatus(ctx context.Context, id primitive.ObjectID, status models.BookingStatus) error {
-	_, err := br.collection.UpdateOne(ctx, bson.M{"_id": id},
+// Transition меняет статус брони, только если он всё ещё равен transition.From,
+// и дописывает переход в историю. Если статус уже сменили, возвращает ErrStatusChanged.
+func (br *BookingRepository) Transition(ctx context.Context, id primitive.ObjectID, transition models.StatusTransition) error {
+	ok, err := br.transition(ctx, bson.M{"_id": id}, transition)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrStatusChanged
+	}
+	return nil
+}
+
+func (br *BookingRepository) transition(ctx context.Context, filter bson.M, transition models.StatusTransition) (bool, error) {
+	filter["status"] = transition.From
+
+	res, err := br.collection.UpdateOne(ctx, filter,
 		bson.M{
 			"$set": bson.M{
-				"status":     status,
-				"updated_at": time.Now(),
+				"status":     transition.To,
+				"updated_at": transition.At,
 			},
+			"$push": bson.M{"history": transition},
 		},
 	)
-	return err
+	if err != nil {
+		return false, err
+	}
+
+	return res.ModifiedCount == 1, nil
 }
 
 func (br *BookingRepository) FindExpiredReservation(ctx context.Context) ([]models.Booking, error) {
@@ -79,6 +110,152 @@ func (br *BookingRepository) FindExpiredReservation(ctx context.Context) ([]mode
 	return bookings, nil
 }
 
+// FindExpiredPending брони, оплата которых не завершилась до конца резерва
+func (br *BookingRepository) FindExpiredPending(ctx context.Context) ([]models.Booking, error) {
+	cursor, err := br.collection.Find(ctx, bson.M{
+		"status":         models.BookingStatusPending,
+		"reserved_until": bson.M{"$lt": time.Now()},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var bookings []models.Booking
+	if err := cursor.All(ctx, &bookings); err != nil {
+		return nil, err
+	}
+	return bookings, nil
+}
+
+// FindExpiredModifications брони с обменом, который не дождался доплаты
+func (br *BookingRepository) FindExpiredModifications(ctx context.Context) ([]models.Booking, error) {
+	cursor, err := br.collection.Find(ctx, bson.M{
+		"modifications": bson.M{"$elemMatch": bson.M{
+			"status":     models.ModificationStatusPending,
+			"expires_at": bson.M{"$lt": time.Now()},
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var bookings []models.Booking
+	if err := cursor.All(ctx, &bookings); err != nil {
+		return nil, err
+	}
+	return bookings, nil
+}
+
+func (br *BookingRepository) ExpireReservation(ctx context.Context, id primitive.ObjectID, transition models.StatusTransition) (bool, error) {
+	return br.transition(ctx,
+		bson.M{
+			"_id":            id,
+			"reserved_until": bson.M{"$lt": transition.At},
+		},
+		transition,
+	)
+}
+
+// ExtendReservation продлевает резерв до until, если бронь всё ещё в reserved и срок не менялся
+// с момента чтения (prevUntil). countExtension учитывает продление в лимите пользователя.
+func (br *BookingRepository) ExtendReservation(ctx context.Context, id primitive.ObjectID, prevUntil, until time.Time, countExtension bool) (bool, error) {
+	update := bson.M{"$set": bson.M{"reserved_until": until, "updated_at": time.Now()}}
+	if countExtension {
+		update["$inc"] = bson.M{"hold_extensions": 1}
+	}
+
+	res, err := br.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "status": models.BookingStatusReserved, "reserved_until": prevUntil},
+		update,
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+// UpdateRefundState сохраняет суммы возвратов и возвращённые билеты брони. Обновление проходит,
+// только если refunded_amount и число возвращённых билетов каждой строки не изменились с момента чтения
+// (prevRefunded, prevQuantities), иначе ErrStatusChanged: так два параллельных возврата не вернут больше,
+// чем было оплачено, и не вернут одни и те же билеты дважды, даже если это бесплатные билеты.
+func (br *BookingRepository) UpdateRefundState(ctx context.Context, booking *models.Booking, prevRefunded money.Amount, prevQuantities []int) error {
+	filter := bson.M{
+		"_id":             booking.ID,
+		"status":          models.BookingStatusConfirmed,
+		"refunded_amount": prevRefunded,
+		"tickets":         bson.M{"$size": len(prevQuantities)},
+	}
+	if prevRefunded == 0 {
+		// У броней, созданных до появления возвратов, поля нет
+		filter["refunded_amount"] = bson.M{"$in": bson.A{prevRefunded, nil}}
+	}
+	for i, quantity := range prevQuantities {
+		key := fmt.Sprintf("tickets.%d.refunded_quantity", i)
+		if quantity == 0 {
+			// Нулевое количество не сохраняется (omitempty)
+			filter[key] = bson.M{"$in": bson.A{0, nil}}
+			continue
+		}
+		filter[key] = quantity
+	}
+
+	res, err := br.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{
+		"refunded_amount": booking.RefundedAmount,
+		"refunded_fee":    booking.RefundedFee,
+		"tickets":         booking.Tickets,
+		"updated_at":      time.Now(),
+	}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrStatusChanged
+	}
+	return nil
+}
+
+// UpdateTickets сохраняет изменённые строки, суммы и обмены брони. Обновление проходит,
+// только если статус брони тот же и она не менялась с prevUpdatedAt, иначе ErrStatusChanged.
+func (br *BookingRepository) UpdateTickets(ctx context.Context, booking *models.Booking, prevUpdatedAt time.Time) error {
+	res, err := br.collection.UpdateOne(ctx,
+		bson.M{"_id": booking.ID, "status": booking.Status, "updated_at": prevUpdatedAt},
+		bson.M{"$set": bson.M{
+			"tickets":       booking.Tickets,
+			"subtotal":      booking.Subtotal,
+			"discount":      booking.Discount,
+			"service_free":  booking.ServiceFree,
+			"fees":          booking.Fees,
+			"total_amount":  booking.TotalAmount,
+			"modifications": booking.Modifications,
+			"updated_at":    booking.UpdatedAt,
+		}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrStatusChanged
+	}
+	return nil
+}
+
+// SetModificationPayment сохраняет платёж доплаты за обмен. updated_at меняется, чтобы
+// параллельный UpdateTickets со старой копией обменов не затёр платёж.
+func (br *BookingRepository) SetModificationPayment(ctx context.Context, bookingID, modificationID primitive.ObjectID, paymentID string) error {
+	now := time.Now()
+	_, err := br.collection.UpdateOne(ctx,
+		bson.M{"_id": bookingID, "modifications.id": modificationID},
+		bson.M{"$set": bson.M{
+			"modifications.$.payment_id": paymentID,
+			"modifications.$.updated_at": now,
+			"updated_at":                 now,
+		}},
+	)
+	return err
+}
+
 func (br *BookingRepository) FindByPaymentID(ctx context.Context, paymentID string) (*models.Booking, error) {
 	var booking models.Booking
 
@@ -100,11 +277,101 @@ func (br *BookingRepository) UpdatePaymentID(ctx context.Context, bookingID prim
 	return err
 }
 
-func (br *BookingRepository) CreateIndexes(ctx context.Context) error {
-	indexModel := mongo.IndexModel{
-		Keys: bson.M{"payment_id": 1},
+type BookingFilter struct {
+	UserID    primitive.ObjectID
+	EventID   primitive.ObjectID
+	Status    models.BookingStatus
+	Cursor    string
+	Limit     int
+	Ascending bool
+}
+
+// ListByUser возвращает страницу броней пользователя, отсортированную по created_at (и _id при равенстве),
+// и курсор следующей страницы. Пустой курсор означает, что страниц больше нет.
+func (br *BookingRepository) ListByUser(ctx context.Context, f BookingFilter) ([]models.Booking, string, error) {
+	filter := bson.M{"user_id": f.UserID}
+	if !f.EventID.IsZero() {
+		filter["event_id"] = f.EventID
+	}
+	if f.Status != "" {
+		filter["status"] = f.Status
+	}
+
+	order, cmp := -1, "$lt"
+	if f.Ascending {
+		order, cmp = 1, "$gt"
+	}
+
+	if f.Cursor != "" {
+		createdAt, id, err := decodeBookingCursor(f.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		filter["$or"] = bson.A{
+			bson.M{"created_at": bson.M{cmp: createdAt}},
+			bson.M{"created_at": createdAt, "_id": bson.M{cmp: id}},
+		}
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: order}, {Key: "_id", Value: order}}).
+		SetLimit(int64(f.Limit + 1))
+
+	cursor, err := br.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	defer cursor.Close(ctx)
+
+	bookings := []models.Booking{}
+	if err := cursor.All(ctx, &bookings); err != nil {
+		return nil, "", err
+	}
+
+	if len(bookings) <= f.Limit {
+		return bookings, "", nil
+	}
+
+	bookings = bookings[:f.Limit]
+	last := bookings[len(bookings)-1]
+	return bookings, encodeBookingCursor(last.CreatedAt, last.ID), nil
+}
+
+func encodeBookingCursor(createdAt time.Time, id primitive.ObjectID) string {
+	raw := strconv.FormatInt(createdAt.UnixMilli(), 10) + ":" + id.Hex()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeBookingCursor(cursor string) (time.Time, primitive.ObjectID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, primitive.NilObjectID, ErrInvalidCursor
+	}
+
+	millis, hex, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return time.Time{}, primitive.NilObjectID, ErrInvalidCursor
+	}
+	ms, err := strconv.ParseInt(millis, 10, 64)
+	if err != nil {
+		return time.Time{}, primitive.NilObjectID, ErrInvalidCursor
 	}
-	_, err := br.collection.Indexes().CreateOne(ctx, indexModel)
+	id, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		return time.Time{}, primitive.NilObjectID, ErrInvalidCursor
+	}
+
+	return time.UnixMilli(ms), id, nil
+}
+
+func (br *BookingRepository) CreateIndexes(ctx context.Context) error {
+	_, err := br.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.M{"payment_id": 1}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "status", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "event_id", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "modifications.status", Value: 1}, {Key: "modifications.expires_at", Value: 1}}},
+	})
 
 	return err
 }
@@ -0,0 +1,83 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/DrummDaddy/Booking_service/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type FeePolicyRepository struct {
+	collection *mongo.Collection
+}
+
+func NewFeePolicyRepository(db *mongo.Database) *FeePolicyRepository {
+	return &FeePolicyRepository{
+		collection: db.Collection("fee_policies"),
+	}
+}
+
+// FindForEvent возвращает политику мероприятия, а если её нет, политику организатора.
+// nil без ошибки означает, что действуют правила по умолчанию.
+func (fr *FeePolicyRepository) FindForEvent(ctx context.Context, eventID, organizerID primitive.ObjectID) (*models.FeePolicy, error) {
+	policy, err := fr.findOne(ctx, bson.M{"event_id": eventID})
+	if err != nil || policy != nil || organizerID.IsZero() {
+		return policy, err
+	}
+	return fr.findOne(ctx, bson.M{"organizer_id": organizerID})
+}
+
+// SaveForEvent заменяет политику мероприятия
+func (fr *FeePolicyRepository) SaveForEvent(ctx context.Context, eventID primitive.ObjectID, rules []models.FeeRule) (*models.FeePolicy, error) {
+	return fr.save(ctx, bson.M{"event_id": eventID}, rules)
+}
+
+// SaveForOrganizer заменяет политику организатора, действующую для всех его мероприятий без своей политики
+func (fr *FeePolicyRepository) SaveForOrganizer(ctx context.Context, organizerID primitive.ObjectID, rules []models.FeeRule) (*models.FeePolicy, error) {
+	return fr.save(ctx, bson.M{"organizer_id": organizerID}, rules)
+}
+
+func (fr *FeePolicyRepository) save(ctx context.Context, filter bson.M, rules []models.FeeRule) (*models.FeePolicy, error) {
+	var policy models.FeePolicy
+	err := fr.collection.FindOneAndUpdate(ctx, filter,
+		bson.M{"$set": bson.M{"rules": rules, "updated_at": time.Now()}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&policy)
+	if err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+func (fr *FeePolicyRepository) findOne(ctx context.Context, filter bson.M) (*models.FeePolicy, error) {
+	var policy models.FeePolicy
+	err := fr.collection.FindOne(ctx, filter).Decode(&policy)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+func (fr *FeePolicyRepository) CreateIndexes(ctx context.Context) error {
+	_, err := fr.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.M{"event_id": 1},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"event_id": bson.M{"$exists": true}}),
+		},
+		// У политики мероприятия organizer_id не заполняется, поэтому индексы не пересекаются
+		{
+			Keys:    bson.M{"organizer_id": 1},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"organizer_id": bson.M{"$exists": true}}),
+		},
+	})
+
+	return err
+}
@@ -0,0 +1,336 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/DrummDaddy/Booking_service/internal/models"
+	"github.com/DrummDaddy/Booking_service/internal/money"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type migration struct {
+	ID  string
+	Run func(ctx context.Context, db *mongo.Database) error
+}
+
+// Миграции выполняются по порядку, каждая один раз. Применённые хранятся в schema_migrations,
+// при этом каждая миграция должна быть идемпотентной: две реплики могут стартовать одновременно.
+var migrations = []migration{
+	// Идёт первой: 0001 округляет цены уже в ticket_types
+	{ID: "0000_event_ticket_types", Run: migrateEventTicketTypes},
+	{ID: "0001_money_minor_units", Run: migrateMoneyMinorUnits},
+	{ID: "0002_booking_refunds", Run: migrateBookingRefunds},
+	{ID: "0003_reserved_count", Run: migrateReservedCount},
+}
+
+type Migrator struct {
+	db         *mongo.Database
+	collection *mongo.Collection
+}
+
+func NewMigrator(db *mongo.Database) *Migrator {
+	return &Migrator{
+		db:         db,
+		collection: db.Collection("schema_migrations"),
+	}
+}
+
+func (m *Migrator) Run(ctx context.Context) error {
+	for _, mg := range migrations {
+		count, err := m.collection.CountDocuments(ctx, bson.M{"_id": mg.ID})
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+
+		if err := mg.Run(ctx, m.db); err != nil {
+			return fmt.Errorf("migration %s: %w", mg.ID, err)
+		}
+		if _, err := m.collection.InsertOne(ctx, bson.M{"_id": mg.ID, "applied_at": time.Now()}); err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+		log.Printf("migration %s applied", mg.ID)
+	}
+	return nil
+}
+
+// migrateEventTicketTypes переименовывает поле ticket_type мероприятий в ticket_types: модель читала
+// ticket_type, а атомарные обновления остатков всегда писали в ticket_types
+func migrateEventTicketTypes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("events").UpdateMany(ctx,
+		bson.M{"ticket_type": bson.M{"$exists": true}, "ticket_types": bson.M{"$exists": false}},
+		bson.M{"$rename": bson.M{"ticket_type": "ticket_types"}},
+	)
+	return err
+}
+
+// migrateMoneyMinorUnits округляет суммы, сохранённые как float64, до копеек по правилам money.Amount
+// и приводит их к double, чтобы в базе не оставалось значений вида 1234.5600000000002.
+func migrateMoneyMinorUnits(ctx context.Context, db *mongo.Database) error {
+	if err := roundAmounts(ctx, db.Collection("bookings"), func(doc bson.M) bson.M {
+		set := roundFields(doc, "", "subtotal", "service_free", "total_amount")
+		if tickets, ok := doc["tickets"].(bson.A); ok {
+			for i, ticket := range tickets {
+				if ticket, ok := ticket.(bson.M); ok {
+					mergeSet(set, roundFields(ticket, "tickets."+strconv.Itoa(i)+".", "unit_price", "total_price"))
+				}
+			}
+		}
+		if refund, ok := doc["refund"].(bson.M); ok {
+			mergeSet(set, roundFields(refund, "refund.", "amount"))
+		}
+		return set
+	}); err != nil {
+		return err
+	}
+
+	if err := roundAmounts(ctx, db.Collection("events"), func(doc bson.M) bson.M {
+		set := bson.M{}
+		if ticketTypes, ok := doc["ticket_types"].(bson.A); ok {
+			for i, tt := range ticketTypes {
+				if tt, ok := tt.(bson.M); ok {
+					mergeSet(set, roundFields(tt, "ticket_types."+strconv.Itoa(i)+".", "price"))
+				}
+			}
+		}
+		return set
+	}); err != nil {
+		return err
+	}
+
+	return roundAmounts(ctx, db.Collection("payments"), func(doc bson.M) bson.M {
+		return roundFields(doc, "", "amount")
+	})
+}
+
+// migrateBookingRefunds переносит возврат, который раньше хранился в брони (поле refund), в коллекцию refunds.
+// Такие возвраты всегда были полными и оформлялись при отмене брони, поэтому билеты уже вернулись в продажу.
+func migrateBookingRefunds(ctx context.Context, db *mongo.Database) error {
+	bookings := db.Collection("bookings")
+	refunds := db.Collection("refunds")
+
+	cursor, err := bookings.Find(ctx, bson.M{"refund": bson.M{"$exists": true}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID        primitive.ObjectID `bson:"_id"`
+			PaymentID string             `bson:"payment_id"`
+			Refund    *struct {
+				ID        string              `bson:"id"`
+				Amount    money.Amount        `bson:"amount"`
+				Currency  string              `bson:"currency"`
+				Status    models.RefundStatus `bson:"status"`
+				Reason    string              `bson:"reason"`
+				Receipt   *models.Receipt     `bson:"receipt"`
+				CreatedAt time.Time           `bson:"created_at"`
+				UpdatedAt time.Time           `bson:"updated_at"`
+			} `bson:"refund"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+
+		set := bson.M{}
+		if legacy := doc.Refund; legacy != nil && legacy.ID != "" {
+			refund := models.Refund{
+				ID:               primitive.NewObjectID(),
+				BookingID:        doc.ID,
+				PaymentID:        doc.PaymentID,
+				ProviderRefundID: legacy.ID,
+				Amount:           legacy.Amount,
+				Currency:         legacy.Currency,
+				Status:           legacy.Status,
+				Reason:           legacy.Reason,
+				Actor:            models.ActorSystem,
+				Receipt:          legacy.Receipt,
+				CreatedAt:        legacy.CreatedAt,
+				UpdatedAt:        legacy.UpdatedAt,
+			}
+			// Повторный запуск после сбоя: запись уже перенесена
+			if _, err := refunds.InsertOne(ctx, refund); err != nil && !mongo.IsDuplicateKeyError(err) {
+				return err
+			}
+			if legacy.Status != models.RefundStatusCanceled {
+				set["refunded_amount"] = legacy.Amount
+			}
+		}
+
+		update := bson.M{"$unset": bson.M{"refund": ""}}
+		if len(set) > 0 {
+			update["$set"] = set
+		}
+		if _, err := bookings.UpdateByID(ctx, doc.ID, update); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// migrateReservedCount переносит билеты открытых броней (reserved, pending) из sold_count в reserved_count.
+// До разделения счётчиков резерв сразу увеличивал sold_count, а reserved_count у типов билетов не было.
+// Тип билета обновляется только пока у него нет reserved_count, поэтому повторный запуск ничего не меняет.
+func migrateReservedCount(ctx context.Context, db *mongo.Database) error {
+	events := db.Collection("events")
+	bookings := db.Collection("bookings")
+
+	cursor, err := events.Find(ctx, bson.M{"ticket_types": bson.M{"$elemMatch": bson.M{"reserved_count": bson.M{"$exists": false}}}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var event struct {
+			ID          primitive.ObjectID `bson:"_id"`
+			TicketTypes []bson.M           `bson:"ticket_types"`
+		}
+		if err := cursor.Decode(&event); err != nil {
+			return err
+		}
+
+		held, err := openBookingTickets(ctx, bookings, event.ID)
+		if err != nil {
+			return err
+		}
+
+		for _, tt := range event.TicketTypes {
+			if _, ok := tt["reserved_count"]; ok {
+				continue
+			}
+			ticketTypeID, ok := tt["_id"].(primitive.ObjectID)
+			if !ok {
+				continue
+			}
+			quantity := held[ticketTypeID]
+			if sold := intValue(tt["sold_count"]); quantity > sold {
+				quantity = sold
+			}
+
+			_, err := events.UpdateOne(ctx,
+				bson.M{"_id": event.ID, "ticket_types": bson.M{"$elemMatch": bson.M{"_id": ticketTypeID, "reserved_count": bson.M{"$exists": false}}}},
+				bson.M{
+					"$set": bson.M{"ticket_types.$[tt].reserved_count": quantity},
+					"$inc": bson.M{"ticket_types.$[tt].sold_count": -quantity},
+				},
+				options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"tt._id": ticketTypeID}}}),
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return cursor.Err()
+}
+
+// openBookingTickets число билетов каждого типа в неоплаченных бронях мероприятия
+func openBookingTickets(ctx context.Context, bookings *mongo.Collection, eventID primitive.ObjectID) (map[primitive.ObjectID]int, error) {
+	cursor, err := bookings.Find(ctx, bson.M{
+		"event_id": eventID,
+		"status":   bson.M{"$in": bson.A{models.BookingStatusReserved, models.BookingStatusPending}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	held := make(map[primitive.ObjectID]int)
+	for cursor.Next(ctx) {
+		var booking struct {
+			Tickets []struct {
+				TicketTypeID primitive.ObjectID `bson:"ticket_type_id"`
+				Quantity     int                `bson:"quantity"`
+			} `bson:"tickets"`
+		}
+		if err := cursor.Decode(&booking); err != nil {
+			return nil, err
+		}
+		for _, ticket := range booking.Tickets {
+			held[ticket.TicketTypeID] += ticket.Quantity
+		}
+	}
+	return held, cursor.Err()
+}
+
+func intValue(v interface{}) int {
+	switch n := v.(type) {
+	case int32:
+		return int(n)
+	case int64:
+		return int(n)
+	case float64:
+		return int(n)
+	}
+	return 0
+}
+
+func roundAmounts(ctx context.Context, collection *mongo.Collection, fields func(doc bson.M) bson.M) error {
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+
+		set := fields(doc)
+		if len(set) == 0 {
+			continue
+		}
+		if _, err := collection.UpdateByID(ctx, doc["_id"], bson.M{"$set": set}); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// roundFields возвращает $set для полей документа, значение которых не совпадает с округлённым
+func roundFields(doc bson.M, prefix string, names ...string) bson.M {
+	set := bson.M{}
+	for _, name := range names {
+		raw, ok := doc[name]
+		if !ok || raw == nil {
+			continue
+		}
+
+		data, err := bson.Marshal(bson.M{"v": raw})
+		if err != nil {
+			continue
+		}
+		var rounded struct {
+			V money.Amount `bson:"v"`
+		}
+		if err := bson.Unmarshal(data, &rounded); err != nil {
+			log.Printf("migration: skip %s%s: %v", prefix, name, err)
+			continue
+		}
+
+		if f, ok := raw.(float64); ok && f == rounded.V.Float64() {
+			continue
+		}
+		set[prefix+name] = rounded.V
+	}
+	return set
+}
+
+func mergeSet(dst, src bson.M) {
+	for k, v := range src {
+		dst[k] = v
+	}
+}
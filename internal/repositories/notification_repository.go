@@ -0,0 +1,92 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/DrummDaddy/Booking_service/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Уведомление в processing дольше этого времени считается брошенным и может быть взято повторно
+const notificationProcessingTimeout = 5 * time.Minute
+
+type NotificationRepository struct {
+	collection *mongo.Collection
+}
+
+func NewNotificationRepository(db *mongo.Database) *NotificationRepository {
+	return &NotificationRepository{
+		collection: db.Collection("payment_notifications"),
+	}
+}
+
+// Save сохраняет уведомление, если уведомления с таким EventKey ещё нет
+func (nr *NotificationRepository) Save(ctx context.Context, notification *models.PaymentNotification) error {
+	_, err := nr.collection.UpdateOne(ctx,
+		bson.M{"event_key": notification.EventKey},
+		bson.M{"$setOnInsert": notification},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+// Claim забирает уведомление в обработку. false означает, что оно уже обработано или обрабатывается другим запросом.
+func (nr *NotificationRepository) Claim(ctx context.Context, eventKey string) (bool, error) {
+	now := time.Now()
+	res, err := nr.collection.UpdateOne(ctx,
+		bson.M{
+			"event_key": eventKey,
+			"$or": bson.A{
+				bson.M{"status": bson.M{"$in": bson.A{models.NotificationStatusReceived, models.NotificationStatusFailed}}},
+				bson.M{
+					"status":     models.NotificationStatusProcessing,
+					"updated_at": bson.M{"$lt": now.Add(-notificationProcessingTimeout)},
+				},
+			},
+		},
+		bson.M{
+			"$set": bson.M{"status": models.NotificationStatusProcessing, "updated_at": now},
+			"$inc": bson.M{"attempts": 1},
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return res.ModifiedCount == 1, nil
+}
+
+func (nr *NotificationRepository) MarkProcessed(ctx context.Context, eventKey string) error {
+	now := time.Now()
+	_, err := nr.collection.UpdateOne(ctx,
+		bson.M{"event_key": eventKey},
+		bson.M{
+			"$set":   bson.M{"status": models.NotificationStatusProcessed, "updated_at": now, "processed_at": now},
+			"$unset": bson.M{"error": ""},
+		},
+	)
+	return err
+}
+
+func (nr *NotificationRepository) MarkFailed(ctx context.Context, eventKey string, cause error) error {
+	_, err := nr.collection.UpdateOne(ctx,
+		bson.M{"event_key": eventKey},
+		bson.M{"$set": bson.M{"status": models.NotificationStatusFailed, "updated_at": time.Now(), "error": cause.Error()}},
+	)
+	return err
+}
+
+func (nr *NotificationRepository) CreateIndexes(ctx context.Context) error {
+	_, err := nr.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.M{"event_key": 1}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.M{"object_id": 1}},
+	})
+
+	return err
+}
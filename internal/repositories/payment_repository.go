@@ -0,0 +1,117 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/DrummDaddy/Booking_service/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrPaymentNotFound = errors.New("payment not found")
+
+type PaymentRepository struct {
+	collection *mongo.Collection
+}
+
+func NewPaymentRepository(db *mongo.Database) *PaymentRepository {
+	return &PaymentRepository{
+		collection: db.Collection("payments"),
+	}
+}
+
+func (pr *PaymentRepository) Create(ctx context.Context, payment *models.Payment) error {
+	_, err := pr.collection.InsertOne(ctx, payment)
+	return err
+}
+
+func (pr *PaymentRepository) FindByProviderID(ctx context.Context, providerPaymentID string) (*models.Payment, error) {
+	var payment models.Payment
+
+	err := pr.collection.FindOne(ctx, bson.M{"provider_payment_id": providerPaymentID}).Decode(&payment)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrPaymentNotFound
+		}
+		return nil, err
+	}
+
+	return &payment, nil
+}
+
+func (pr *PaymentRepository) FindByBookingID(ctx context.Context, bookingID primitive.ObjectID) ([]models.Payment, error) {
+	cursor, err := pr.collection.Find(ctx, bson.M{"booking_id": bookingID},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var payments []models.Payment
+	if err := cursor.All(ctx, &payments); err != nil {
+		return nil, err
+	}
+	return payments, nil
+}
+
+// FindNonFinal возвращает платежи без итогового статуса, созданные в [from, to).
+// Нулевые границы не ограничивают выборку.
+func (pr *PaymentRepository) FindNonFinal(ctx context.Context, from, to time.Time) ([]models.Payment, error) {
+	filter := bson.M{
+		"status": bson.M{"$in": bson.A{models.PaymentStatusPending, models.PaymentStatusWaitingForCapture}},
+	}
+	createdAt := bson.M{}
+	if !from.IsZero() {
+		createdAt["$gte"] = from
+	}
+	if !to.IsZero() {
+		createdAt["$lt"] = to
+	}
+	if len(createdAt) > 0 {
+		filter["created_at"] = createdAt
+	}
+
+	cursor, err := pr.collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var payments []models.Payment
+	if err := cursor.All(ctx, &payments); err != nil {
+		return nil, err
+	}
+	return payments, nil
+}
+
+// UpdateStatus сохраняет статус платежа и сырой ответ провайдера, из которого он получен
+func (pr *PaymentRepository) UpdateStatus(ctx context.Context, id primitive.ObjectID, status models.PaymentStatus, response models.ProviderResponse) error {
+	_, err := pr.collection.UpdateOne(ctx, bson.M{"_id": id},
+		bson.M{
+			"$set": bson.M{
+				"status":     status,
+				"updated_at": time.Now(),
+			},
+			"$push": bson.M{"provider_responses": response},
+		},
+	)
+	return err
+}
+
+func (pr *PaymentRepository) CreateIndexes(ctx context.Context) error {
+	_, err := pr.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.M{"provider_payment_id": 1},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"provider_payment_id": bson.M{"$type": "string"}}),
+		},
+		{Keys: bson.D{{Key: "booking_id", Value: 1}, {Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}}},
+	})
+
+	return err
+}
@@ -0,0 +1,140 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+
+	"github.com/DrummDaddy/Booking_service/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrPromoNotFound      = errors.New("promo code not found")
+	ErrPromoCodeExists    = errors.New("promo code already exists")
+	ErrPromoExhausted     = errors.New("promo code usage limit reached")
+	ErrPromoUserExhausted = errors.New("promo code usage limit per user reached")
+)
+
+type PromoRepository struct {
+	collection *mongo.Collection
+	// usage счётчики применений по пользователям: {promo_id, user_id, count}
+	usage *mongo.Collection
+}
+
+func NewPromoRepository(db *mongo.Database) *PromoRepository {
+	return &PromoRepository{
+		collection: db.Collection("promo_codes"),
+		usage:      db.Collection("promo_usage"),
+	}
+}
+
+func (pr *PromoRepository) Create(ctx context.Context, promo *models.PromoCode) error {
+	res, err := pr.collection.InsertOne(ctx, promo)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrPromoCodeExists
+	}
+	if err != nil {
+		return err
+	}
+	promo.ID = res.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (pr *PromoRepository) FindByCode(ctx context.Context, code string) (*models.PromoCode, error) {
+	var promo models.PromoCode
+	err := pr.collection.FindOne(ctx, bson.M{"code": code}).Decode(&promo)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrPromoNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &promo, nil
+}
+
+func (pr *PromoRepository) List(ctx context.Context) ([]models.PromoCode, error) {
+	cursor, err := pr.collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	promos := []models.PromoCode{}
+	if err := cursor.All(ctx, &promos); err != nil {
+		return nil, err
+	}
+	return promos, nil
+}
+
+// Redeem занимает одно применение промокода для пользователя.
+// Оба счётчика проверяются и увеличиваются в одном запросе, поэтому параллельные брони не превысят лимиты.
+func (pr *PromoRepository) Redeem(ctx context.Context, promo *models.PromoCode, userID primitive.ObjectID) error {
+	filter := bson.M{"_id": promo.ID, "active": true}
+	if promo.MaxRedemptions > 0 {
+		filter["redemptions"] = bson.M{"$lt": promo.MaxRedemptions}
+	}
+	res, err := pr.collection.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"redemptions": 1}})
+	if err != nil {
+		return err
+	}
+	if res.ModifiedCount == 0 {
+		return ErrPromoExhausted
+	}
+
+	usageFilter := bson.M{"promo_id": promo.ID, "user_id": userID}
+	if promo.MaxPerUser > 0 {
+		usageFilter["count"] = bson.M{"$lt": promo.MaxPerUser}
+	}
+	// Если лимит пользователя исчерпан, фильтр не находит документ и upsert упирается в уникальный индекс
+	_, err = pr.usage.UpdateOne(ctx, usageFilter, bson.M{"$inc": bson.M{"count": 1}}, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrPromoUserExhausted
+	}
+	return err
+}
+
+// UsageCount число действующих применений промокода пользователем
+func (pr *PromoRepository) UsageCount(ctx context.Context, promoID, userID primitive.ObjectID) (int, error) {
+	var usage struct {
+		Count int `bson:"count"`
+	}
+	err := pr.usage.FindOne(ctx, bson.M{"promo_id": promoID, "user_id": userID}).Decode(&usage)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, nil
+	}
+	return usage.Count, err
+}
+
+// Release возвращает применение промокода после отмены или истечения брони
+func (pr *PromoRepository) Release(ctx context.Context, promoID, userID primitive.ObjectID) error {
+	if _, err := pr.collection.UpdateOne(ctx,
+		bson.M{"_id": promoID, "redemptions": bson.M{"$gt": 0}},
+		bson.M{"$inc": bson.M{"redemptions": -1}},
+	); err != nil {
+		return err
+	}
+
+	_, err := pr.usage.UpdateOne(ctx,
+		bson.M{"promo_id": promoID, "user_id": userID, "count": bson.M{"$gt": 0}},
+		bson.M{"$inc": bson.M{"count": -1}},
+	)
+	return err
+}
+
+func (pr *PromoRepository) CreateIndexes(ctx context.Context) error {
+	if _, err := pr.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"code": 1},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return err
+	}
+
+	_, err := pr.usage.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "promo_id", Value: 1}, {Key: "user_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
@@ -0,0 +1,119 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/DrummDaddy/Booking_service/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrRefundNotFound = errors.New("refund not found")
+
+type RefundRepository struct {
+	collection *mongo.Collection
+}
+
+func NewRefundRepository(db *mongo.Database) *RefundRepository {
+	return &RefundRepository{
+		collection: db.Collection("refunds"),
+	}
+}
+
+func (rr *RefundRepository) Create(ctx context.Context, refund *models.Refund) error {
+	_, err := rr.collection.InsertOne(ctx, refund)
+	return err
+}
+
+func (rr *RefundRepository) FindByProviderID(ctx context.Context, providerRefundID string) (*models.Refund, error) {
+	var refund models.Refund
+	err := rr.collection.FindOne(ctx, bson.M{"provider_refund_id": providerRefundID}).Decode(&refund)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrRefundNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &refund, nil
+}
+
+func (rr *RefundRepository) ListByBooking(ctx context.Context, bookingID primitive.ObjectID) ([]models.Refund, error) {
+	cursor, err := rr.collection.Find(ctx, bson.M{"booking_id": bookingID},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	refunds := []models.Refund{}
+	if err := cursor.All(ctx, &refunds); err != nil {
+		return nil, err
+	}
+	return refunds, nil
+}
+
+// FindPending возвращает возвраты в pending, созданные в [from, to). Нулевые границы не ограничивают выборку.
+func (rr *RefundRepository) FindPending(ctx context.Context, from, to time.Time) ([]models.Refund, error) {
+	filter := bson.M{"status": models.RefundStatusPending}
+	createdAt := bson.M{}
+	if !from.IsZero() {
+		createdAt["$gte"] = from
+	}
+	if !to.IsZero() {
+		createdAt["$lt"] = to
+	}
+	if len(createdAt) > 0 {
+		filter["created_at"] = createdAt
+	}
+
+	cursor, err := rr.collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var refunds []models.Refund
+	if err := cursor.All(ctx, &refunds); err != nil {
+		return nil, err
+	}
+	return refunds, nil
+}
+
+// SetProviderResult сохраняет ID возврата у провайдера после успешного запроса
+func (rr *RefundRepository) SetProviderResult(ctx context.Context, id primitive.ObjectID, providerRefundID string) error {
+	_, err := rr.collection.UpdateOne(ctx, bson.M{"_id": id},
+		bson.M{"$set": bson.M{"provider_refund_id": providerRefundID, "updated_at": time.Now()}},
+	)
+	return err
+}
+
+// UpdateStatus меняет статус, только если возврат ещё в статусе from. Возвращает false, если его уже обработали.
+func (rr *RefundRepository) UpdateStatus(ctx context.Context, id primitive.ObjectID, from, to models.RefundStatus, errMsg string) (bool, error) {
+	set := bson.M{"status": to, "updated_at": time.Now()}
+	if errMsg != "" {
+		set["error"] = errMsg
+	}
+
+	res, err := rr.collection.UpdateOne(ctx, bson.M{"_id": id, "status": from}, bson.M{"$set": set})
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+func (rr *RefundRepository) CreateIndexes(ctx context.Context) error {
+	_, err := rr.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.M{"provider_refund_id": 1},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"provider_refund_id": bson.M{"$type": "string"}}),
+		},
+		{Keys: bson.D{{Key: "booking_id", Value: 1}, {Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}}},
+	})
+
+	return err
+}
@@ -0,0 +1,54 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/DrummDaddy/Booking_service/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type ReviewRepository struct {
+	collection *mongo.Collection
+}
+
+func NewReviewRepository(db *mongo.Database) *ReviewRepository {
+	return &ReviewRepository{
+		collection: db.Collection("payment_reviews"),
+	}
+}
+
+// Add ставит расхождение в очередь, если по этому платежу ещё нет открытой записи
+func (rr *ReviewRepository) Add(ctx context.Context, review *models.PaymentReview) error {
+	_, err := rr.collection.UpdateOne(ctx,
+		bson.M{"provider_payment_id": review.ProviderPaymentID, "status": models.ReviewStatusOpen},
+		bson.M{"$setOnInsert": review},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func (rr *ReviewRepository) FindOpen(ctx context.Context) ([]models.PaymentReview, error) {
+	cursor, err := rr.collection.Find(ctx, bson.M{"status": models.ReviewStatusOpen},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	reviews := []models.PaymentReview{}
+	if err := cursor.All(ctx, &reviews); err != nil {
+		return nil, err
+	}
+	return reviews, nil
+}
+
+func (rr *ReviewRepository) CreateIndexes(ctx context.Context) error {
+	_, err := rr.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}}},
+		{Keys: bson.M{"provider_payment_id": 1}},
+	})
+
+	return err
+}
@@ -0,0 +1,128 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/DrummDaddy/Booking_service/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrSeatsUnavailable = errors.New("some of the selected seats are not available")
+
+type SeatRepository struct {
+	collection *mongo.Collection
+}
+
+func NewSeatRepository(db *mongo.Database) *SeatRepository {
+	return &SeatRepository{
+		collection: db.Collection("seats"),
+	}
+}
+
+// ReserveSeats блокирует места за бронью. Должна вызываться в транзакции:
+// если свободны не все места, возвращается ErrSeatsUnavailable и транзакция откатывает частичный захват.
+func (sr *SeatRepository) ReserveSeats(ctx context.Context, eventID, ticketTypeID primitive.ObjectID, seatIDs []primitive.ObjectID, bookingID primitive.ObjectID) ([]models.EventSeat, error) {
+	res, err := sr.collection.UpdateMany(ctx,
+		bson.M{
+			"_id":            bson.M{"$in": seatIDs},
+			"event_id":       eventID,
+			"ticket_type_id": ticketTypeID,
+			"status":         models.SeatStatusFree,
+		},
+		bson.M{
+			"$set": bson.M{
+				"status":     models.SeatStatusReserved,
+				"booking_id": bookingID,
+				"updated_at": time.Now(),
+			},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if res.ModifiedCount != int64(len(seatIDs)) {
+		return nil, ErrSeatsUnavailable
+	}
+
+	cursor, err := sr.collection.Find(ctx, bson.M{"_id": bson.M{"$in": seatIDs}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var seats []models.EventSeat
+	if err := cursor.All(ctx, &seats); err != nil {
+		return nil, err
+	}
+	return seats, nil
+}
+
+// ReleaseSeats освобождает все места брони: и зарезервированные, и проданные (после возврата)
+func (sr *SeatRepository) ReleaseSeats(ctx context.Context, bookingID primitive.ObjectID) error {
+	_, err := sr.collection.UpdateMany(ctx,
+		bson.M{"booking_id": bookingID},
+		bson.M{
+			"$set":   bson.M{"status": models.SeatStatusFree, "updated_at": time.Now()},
+			"$unset": bson.M{"booking_id": ""},
+		},
+	)
+	return err
+}
+
+func (sr *SeatRepository) ConfirmSeats(ctx context.Context, bookingID primitive.ObjectID) error {
+	_, err := sr.collection.UpdateMany(ctx,
+		bson.M{"booking_id": bookingID, "status": models.SeatStatusReserved},
+		bson.M{"$set": bson.M{"status": models.SeatStatusSold, "updated_at": time.Now()}},
+	)
+	return err
+}
+
+// FindFree возвращает свободные места из seatIDs, не блокируя их
+func (sr *SeatRepository) FindFree(ctx context.Context, eventID, ticketTypeID primitive.ObjectID, seatIDs []primitive.ObjectID) ([]models.EventSeat, error) {
+	cursor, err := sr.collection.Find(ctx, bson.M{
+		"_id":            bson.M{"$in": seatIDs},
+		"event_id":       eventID,
+		"ticket_type_id": ticketTypeID,
+		"status":         models.SeatStatusFree,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var seats []models.EventSeat
+	if err := cursor.All(ctx, &seats); err != nil {
+		return nil, err
+	}
+	return seats, nil
+}
+
+func (sr *SeatRepository) CountByBooking(ctx context.Context, bookingID primitive.ObjectID, status models.SeatStatus) (int, error) {
+	count, err := sr.collection.CountDocuments(ctx, bson.M{"booking_id": bookingID, "status": status})
+	return int(count), err
+}
+
+func (sr *SeatRepository) CreateIndexes(ctx context.Context) error {
+	_, err := sr.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "event_id", Value: 1}, {Key: "ticket_type_id", Value: 1}, {Key: "status", Value: 1}}},
+		{Keys: bson.M{"booking_id": 1}},
+	})
+
+	return err
+}
+
+// ReleaseSeatIDs освобождает отдельные места брони, например после возврата части билетов
+func (sr *SeatRepository) ReleaseSeatIDs(ctx context.Context, bookingID primitive.ObjectID, seatIDs []primitive.ObjectID) error {
+	_, err := sr.collection.UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$in": seatIDs}, "booking_id": bookingID},
+		bson.M{
+			"$set":   bson.M{"status": models.SeatStatusFree, "updated_at": time.Now()},
+			"$unset": bson.M{"booking_id": ""},
+		},
+	)
+	return err
+}
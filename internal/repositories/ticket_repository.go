@@ -2,10 +2,19 @@ package repositories
 
 import (
 	"context"
+	"errors"
 
+	"github.com/DrummDaddy/Booking_service/internal/models"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrNotEnoughTickets  = errors.New("not enough tickets available")
+	ErrNotEnoughReserved = errors.New("not enough reserved tickets")
+	ErrNotEnoughSold     = errors.New("not enough sold tickets")
 )
 
 type TicketRepository struct {
@@ -18,36 +27,99 @@ func NewTicketRepository(db *mongo.Database) *TicketRepository {
 	}
 }
 
-func (tr *TicketRepository) ReserveTickets(ctx context.Context, eventID, ticketTypeID primitive.ObjectID, quantity int) error {
-	_, err := tr.collection.UpdateOne(
-		ctx,
-		bson.M{
-			"_id":              eventID,
-			"ticket_types._id": ticketTypeID,
-			"ticket_types.sold_count": bson.M{
-				"$lte": bson.M{"&subtract": []interface{}{"$ticket_types.quantity", quantity}},
-			},
-		},
+// ReserveTickets переводит билеты из свободных в резерв (корзины).
+// Возвращает тип билета в состоянии до резерва: по его счётчикам выбирается ступень цены.
+func (tr *TicketRepository) ReserveTickets(ctx context.Context, eventID, ticketTypeID primitive.ObjectID, quantity int) (*models.TicketType, error) {
+	// Проверка остатка и инкремент в одном запросе, иначе два параллельных резерва продадут больше мест
+	return tr.updateTicketType(ctx, eventID, ticketTypeID,
+		bson.M{"$lte": bson.A{
+			bson.M{"$add": bson.A{"$$tt.sold_count", bson.M{"$ifNull": bson.A{"$$tt.reserved_count", 0}}, quantity}},
+			"$$tt.quantity",
+		}},
+		bson.M{"reserved_count": quantity},
+		ErrNotEnoughTickets,
+	)
+}
+
+// ReleaseTickets возвращает зарезервированные билеты в свободные
+func (tr *TicketRepository) ReleaseTickets(ctx context.Context, eventID, tickeTypeID primitive.ObjectID, quantity int) error {
+	_, err := tr.updateTicketType(ctx, eventID, tickeTypeID,
+		bson.M{"$gte": bson.A{bson.M{"$ifNull": bson.A{"$$tt.reserved_count", 0}}, quantity}},
+		bson.M{"reserved_count": -quantity},
+		ErrNotEnoughReserved,
+	)
+	return err
+}
+
+// ConfirmSale переводит оплаченные билеты из резерва в проданные
+func (tr *TicketRepository) ConfirmSale(ctx context.Context, eventID, ticketTypeID primitive.ObjectID, quantity int) error {
+	_, err := tr.updateTicketType(ctx, eventID, ticketTypeID,
+		bson.M{"$gte": bson.A{bson.M{"$ifNull": bson.A{"$$tt.reserved_count", 0}}, quantity}},
 		bson.M{
-			"$inc": bson.M{"ticket_types.$.sold_count": quantity},
+			"reserved_count": -quantity,
+			"sold_count":     quantity,
 		},
+		ErrNotEnoughReserved,
 	)
 	return err
-
 }
 
-func (tr *TicketRepository) ReleaseTickets(ctx context.Context, eventID, tickeTypeID primitive.ObjectID, quantity int) error {
-	_, err := tr.collection.UpdateOne(
-		ctx, bson.M{"_id": eventID,
-			"ticket_types._id": tickeTypeID},
-		bson.M{"$inc": bson.M{"ticket_types.$.sold_count": -quantity}},
+// ReturnSoldTickets возвращает проданные билеты в свободные после возврата денег
+func (tr *TicketRepository) ReturnSoldTickets(ctx context.Context, eventID, ticketTypeID primitive.ObjectID, quantity int) error {
+	_, err := tr.updateTicketType(ctx, eventID, ticketTypeID,
+		bson.M{"$gte": bson.A{"$$tt.sold_count", quantity}},
+		bson.M{"sold_count": -quantity},
+		ErrNotEnoughSold,
 	)
 	return err
 }
 
-func (tr *TicketRepository) ConfirmSale(ctx context.Context, eventID, ticketTypeID primitive.ObjectID, quantity int) error {
+// updateTicketType применяет $inc к типу билета, только если для него выполняется cond.
+// В cond тип билета доступен как $$tt, в inc указываются поля типа билета.
+// Возвращает тип билета в состоянии до обновления.
+func (tr *TicketRepository) updateTicketType(ctx context.Context, eventID, ticketTypeID primitive.ObjectID, cond bson.M, inc bson.M, notMatched error) (*models.TicketType, error) {
+	fields := bson.M{}
+	for field, value := range inc {
+		fields["ticket_types.$[tt]."+field] = value
+	}
 
-	// Тут логику пока не продумал((
+	var before models.Event
+	err := tr.collection.FindOneAndUpdate(
+		ctx,
+		bson.M{
+			"_id": eventID,
+			"$expr": bson.M{
+				"$anyElementTrue": bson.A{
+					bson.M{"$map": bson.M{
+						"input": "$ticket_types",
+						"as":    "tt",
+						"in": bson.M{"$and": bson.A{
+							bson.M{"$eq": bson.A{"$$tt._id", ticketTypeID}},
+							cond,
+						}},
+					}},
+				},
+			},
+		},
+		bson.M{"$inc": fields},
+		options.FindOneAndUpdate().
+			SetArrayFilters(options.ArrayFilters{
+				Filters: []interface{}{bson.M{"tt._id": ticketTypeID}},
+			}).
+			SetProjection(bson.M{"ticket_types": 1}).
+			SetReturnDocument(options.Before),
+	).Decode(&before)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, notMatched
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	return nil
+	for i := range before.TicketTypes {
+		if before.TicketTypes[i].ID == ticketTypeID {
+			return &before.TicketTypes[i], nil
+		}
+	}
+	return nil, notMatched
 }
@@ -0,0 +1,36 @@
+package repositories
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type Transactor struct {
+	client *mongo.Client
+}
+
+func NewTransactor(db *mongo.Database) *Transactor {
+	return &Transactor{
+		client: db.Client(),
+	}
+}
+
+// WithTransaction выполняет fn в транзакции MongoDB. Репозитории должны получать ctx из fn,
+// иначе их запросы пойдут мимо сессии. Вложенный вызов переиспользует уже открытую транзакцию.
+func (t *Transactor) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if mongo.SessionFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+
+	session, err := t.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
@@ -0,0 +1,248 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/DrummDaddy/Booking_service/internal/models"
+	"github.com/DrummDaddy/Booking_service/internal/repositories"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ExtendReservation продлевает резерв неоплаченной брони по политике мероприятия:
+// не больше max_extensions раз и не дальше max_hold_minutes от создания брони
+func (bs *BookingService) ExtendReservation(ctx context.Context, bookingID, userID string) (*models.Booking, error) {
+	booking, err := bs.Getbooking(ctx, bookingID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if booking.Status != models.BookingStatusReserved {
+		return nil, fmt.Errorf("%s booking cannot be extended", booking.Status)
+	}
+	if time.Now().After(booking.ReservedUntil) {
+		return nil, ErrReservationExpired
+	}
+	event, err := bs.eventRepo.FindByID(ctx, booking.EventID)
+	if err != nil {
+		return nil, errors.New("event not found")
+	}
+
+	policy := event.HoldPolicy()
+	if booking.HoldExtensions >= policy.MaxExtensions {
+		return nil, errors.New("reservation extension limit reached")
+	}
+	until := policy.Extended(booking.CreatedAt, booking.ReservedUntil)
+	if !until.After(booking.ReservedUntil) {
+		return nil, errors.New("maximum reservation time reached")
+	}
+
+	ok, err := bs.bookingRepo.ExtendReservation(ctx, booking.ID, booking.ReservedUntil, until, true)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, repositories.ErrStatusChanged
+	}
+	return bs.bookingRepo.FindByID(ctx, booking.ID)
+}
+
+// holdForPayment продлевает резерв перед созданием платежа, чтобы бронь не истекла, пока покупатель
+// проходит оплату (3-D Secure) и пока провайдер присылает результат. Лимит продлений пользователя не тратится.
+func (bs *BookingService) holdForPayment(ctx context.Context, booking *models.Booking, event *models.Event) error {
+	now := time.Now()
+	if now.After(booking.ReservedUntil) {
+		return ErrReservationExpired
+	}
+	until := event.HoldPolicy().PaymentHoldUntil(booking.CreatedAt, now)
+	if !until.After(booking.ReservedUntil) {
+		return nil
+	}
+
+	ok, err := bs.bookingRepo.ExtendReservation(ctx, booking.ID, booking.ReservedUntil, until, false)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return repositories.ErrStatusChanged
+	}
+	booking.ReservedUntil = until
+	return nil
+}
+
+// CancelTickets отменяет часть билетов брони. В неоплаченной брони билеты убираются из строк,
+// суммы пересчитываются, а билеты и места сразу возвращаются в продажу. По оплаченной брони
+// оформляется возврат этих билетов, для неоплаченной брони возвратов в ответе нет.
+func (bs *BookingService) CancelTickets(ctx context.Context, bookingID, userID string, req models.CancelTicketsRequest) (*models.Booking, []models.Refund, error) {
+	if len(req.Lines) == 0 {
+		return nil, nil, errors.New("no tickets to cancel")
+	}
+	booking, err := bs.Getbooking(ctx, bookingID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	actor := models.UserActor(userID)
+
+	switch booking.Status {
+	case models.BookingStatusReserved:
+		if time.Now().After(booking.ReservedUntil) {
+			return nil, nil, ErrReservationExpired
+		}
+		if err := bs.cancelReservedTickets(ctx, booking, req, actor); err != nil {
+			return nil, nil, err
+		}
+		return booking, nil, nil
+	case models.BookingStatusConfirmed:
+		refunds, err := bs.refund(ctx, booking, models.RefundRequest{Lines: req.Lines, Reason: req.Reason}, actor)
+		var partial *PartialRefundError
+		if err != nil && !errors.As(err, &partial) {
+			return nil, nil, err
+		}
+		booking, findErr := bs.bookingRepo.FindByID(ctx, booking.ID)
+		if findErr != nil {
+			return nil, nil, findErr
+		}
+		return booking, refunds, err
+	default:
+		// В pending сумма уже передана провайдеру, менять состав брони нельзя
+		return nil, nil, fmt.Errorf("tickets of %s booking cannot be cancelled", booking.Status)
+	}
+}
+
+// cancelReservedTickets убирает билеты из строк неоплаченной брони и пересчитывает её суммы
+// по правилам сборов и промокоду брони. Если билетов не осталось, бронь отменяется целиком.
+func (bs *BookingService) cancelReservedTickets(ctx context.Context, booking *models.Booking, req models.CancelTicketsRequest, actor string) error {
+	tickets := append([]models.BookingTicket(nil), booking.Tickets...)
+	seen := make(map[int]bool, len(req.Lines))
+	var released []models.RefundLine
+	for _, line := range req.Lines {
+		ticket, seats, err := selectTicketLine(tickets, line, seen)
+		if err != nil {
+			return err
+		}
+		ticket.Quantity -= line.Quantity
+		ticket.TotalPrice = ticket.UnitPrice.Mul(ticket.Quantity)
+		ticket.Seats = withoutSeats(ticket.Seats, seats)
+		released = append(released, models.RefundLine{TicketTypeID: ticket.TicketTypeID, Quantity: line.Quantity, Seats: seats})
+	}
+
+	var remaining []models.BookingTicket
+	for _, ticket := range tickets {
+		if ticket.Quantity > 0 {
+			remaining = append(remaining, ticket)
+		}
+	}
+	if len(remaining) == 0 {
+		err := bs.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+			// Транзакция может повториться, поэтому отменяется перечитанная в ней бронь
+			current, err := bs.bookingRepo.FindByID(ctx, booking.ID)
+			if err != nil {
+				return err
+			}
+			if !current.UpdatedAt.Equal(booking.UpdatedAt) {
+				return repositories.ErrStatusChanged
+			}
+			if err := bs.cancel(ctx, current, req.Reason, actor); err != nil {
+				return err
+			}
+			*booking = *current
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		bs.voidOpenHolds(ctx, booking.ID)
+		return nil
+	}
+
+	plan, err := bs.planBookingChange(ctx, booking)
+	if err != nil {
+		return err
+	}
+	totals, err := bs.calculateTotals(plan, remaining)
+	if err != nil {
+		return err
+	}
+
+	prevUpdatedAt := booking.UpdatedAt
+	booking.Tickets = remaining
+	booking.Subtotal = totals.subtotal
+	booking.Discount = totals.discount
+	booking.ServiceFree = totals.serviceFee
+	booking.Fees = totals.fees
+	booking.TotalAmount = totals.total
+	booking.UpdatedAt = time.Now()
+
+	return bs.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := bs.bookingRepo.UpdateTickets(ctx, booking, prevUpdatedAt); err != nil {
+			return err
+		}
+		for _, line := range released {
+			if err := bs.ticketRepo.ReleaseTickets(ctx, booking.EventID, line.TicketTypeID, line.Quantity); err != nil {
+				return err
+			}
+			if len(line.Seats) == 0 {
+				continue
+			}
+			seatIDs, err := seatObjectIDs(line.Seats)
+			if err != nil {
+				return err
+			}
+			if err := bs.seatRepo.ReleaseSeatIDs(ctx, booking.ID, seatIDs); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// planBookingChange данные для пересчёта сумм существующей брони по правилам сборов, действовавшим
+// при её создании. Для броней без сохранённых правил сбор уменьшается пропорционально билетам.
+func (bs *BookingService) planBookingChange(ctx context.Context, booking *models.Booking) (*bookingPlan, error) {
+	event, err := bs.eventRepo.FindByID(ctx, booking.EventID)
+	if err != nil {
+		return nil, errors.New("event not found")
+	}
+	plan := &bookingPlan{event: event, userID: booking.UserID, currency: booking.Currency, feeRules: booking.FeeRules}
+	if booking.FeeRules == nil {
+		plan.prorateFees = true
+		plan.baseFees = booking.Fees
+		for _, ticket := range booking.Tickets {
+			plan.baseUnits += ticket.Quantity
+		}
+	}
+	if booking.Promo != nil {
+		// Промокод уже погашен бронью, поэтому срок действия и лимиты повторно не проверяются
+		if plan.promo, err = bs.promoRepo.FindByCode(ctx, booking.Promo.Code); err != nil {
+			return nil, err
+		}
+	}
+	return plan, nil
+}
+
+func withoutSeats(seats []models.Seat, ids []string) []models.Seat {
+	drop := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		drop[id] = true
+	}
+	var kept []models.Seat
+	for _, seat := range seats {
+		if !drop[seat.SeatID] {
+			kept = append(kept, seat)
+		}
+	}
+	return kept
+}
+
+func seatObjectIDs(ids []string) ([]primitive.ObjectID, error) {
+	seatIDs := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		seatID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid seat ID %s", id)
+		}
+		seatIDs = append(seatIDs, seatID)
+	}
+	return seatIDs, nil
+}
@@ -0,0 +1,485 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/DrummDaddy/Booking_service/internal/models"
+	"github.com/DrummDaddy/Booking_service/internal/money"
+	"github.com/DrummDaddy/Booking_service/internal/repositories"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CreatePayment создаёт платёж по брони. Для расчётов в рублях к платежу прикладывается чек,
+// поэтому нужен email или телефон покупателя. Оплатить можно только свою бронь.
+func (bs *BookingService) CreatePayment(ctx context.Context, bookingID, userID string, returnURL string, customer models.ReceiptCustomer) (string, error) {
+	// Владелец проверяется до продления резерва, чтобы чужая бронь не удерживалась дольше
+	booking, err := bs.Getbooking(ctx, bookingID, userID)
+	if err != nil {
+		return "", err
+	}
+
+	if booking.Status != models.BookingStatusReserved {
+		return "", errors.New("only reserved bookings can be paid")
+
+	}
+
+	event, err := bs.eventRepo.FindByID(ctx, booking.EventID)
+	if err != nil {
+		return "", errors.New("event not found")
+	}
+	if err := bs.holdForPayment(ctx, booking, event); err != nil {
+		return "", err
+	}
+
+	var receipt *models.Receipt
+	if receiptRequired(booking.Currency) {
+		customer, err := normalizeReceiptCustomer(customer)
+		if err != nil {
+			return "", err
+		}
+		receipt = buildReceipt(booking, event, customer)
+	}
+
+	payment := &models.Payment{
+		ID:        primitive.NewObjectID(),
+		BookingID: booking.ID,
+		Amount:    booking.TotalAmount,
+		Currency:  booking.Currency,
+		TwoStage:  event.ManualCapture,
+		Receipt:   receipt,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	result, err := bs.paymentProvider.CreatePayment(ctx, PaymentRequest{
+		OrderID:        booking.ID.Hex(),
+		Amount:         booking.TotalAmount,
+		Currency:       booking.Currency,
+		Description:    "Оплата бронирования",
+		ReturnURL:      returnURL,
+		IdempotenceKey: payment.ID.Hex(),
+		Capture:        !payment.TwoStage,
+		Receipt:        receipt,
+	})
+	if err != nil {
+		// Неудачную попытку тоже сохраняем, чтобы по брони была видна вся история оплаты
+		payment.Status = models.PaymentStatusFailed
+		payment.Error = err.Error()
+		if err := bs.paymentRepo.Create(ctx, payment); err != nil {
+			log.Printf("save failed payment for booking %s: %v", booking.ID.Hex(), err)
+		}
+		return "", err
+	}
+
+	payment.ProviderPaymentID = result.ID
+	payment.Status = models.PaymentStatus(result.Status)
+	payment.ConfirmationURL = result.ConfirmationURL
+	payment.ProviderResponses = []models.ProviderResponse{{
+		Operation:  "create",
+		Body:       string(result.Raw),
+		ReceivedAt: time.Now(),
+	}}
+
+	err = bs.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		// Пока создавался платёж, из брони могли отменить билеты (CancelTickets)
+		current, err := bs.bookingRepo.FindByID(ctx, booking.ID)
+		if err != nil {
+			return err
+		}
+		if current.TotalAmount != booking.TotalAmount {
+			return repositories.ErrStatusChanged
+		}
+
+		if err := bs.paymentRepo.Create(ctx, payment); err != nil {
+			return err
+		}
+		if err := bs.bookingRepo.UpdatePaymentID(ctx, booking.ID, result.ID); err != nil {
+			return err
+		}
+		return bs.transition(ctx, booking, models.BookingStatusPending, "payment "+result.ID+" started", models.ActorSystem)
+	})
+	if err != nil {
+		bs.abandonPayment(context.WithoutCancel(ctx), payment, err)
+		return "", err
+	}
+
+	return payment.ConfirmationURL, nil
+}
+
+// abandonPayment сохраняет платёж, созданный у провайдера, но не привязанный к брони, и отменяет его.
+// Если отменить не удалось, платёж уходит на ручную проверку.
+func (bs *BookingService) abandonPayment(ctx context.Context, payment *models.Payment, cause error) {
+	payment.Error = cause.Error()
+	if err := bs.paymentRepo.Create(ctx, payment); err != nil {
+		log.Printf("save abandoned payment %s: %v", payment.ProviderPaymentID, err)
+	}
+
+	if err := bs.voidPayment(ctx, payment); err != nil {
+		log.Printf("cancel abandoned payment %s: %v", payment.ProviderPaymentID, err)
+		if err := bs.flagForReview(ctx, payment, "payment was not attached to booking and could not be canceled: "+cause.Error()); err != nil {
+			log.Printf("flag payment %s for review: %v", payment.ProviderPaymentID, err)
+		}
+	}
+}
+
+// SyncPayment запрашивает у провайдера актуальный статус платежа, сохраняет его и применяет к брони
+func (bs *BookingService) SyncPayment(ctx context.Context, paymentID string) error {
+	payment, err := bs.paymentRepo.FindByProviderID(ctx, paymentID)
+	if err != nil {
+		return err
+	}
+
+	_, err = bs.syncPayment(ctx, payment)
+	return err
+}
+
+func (bs *BookingService) syncPayment(ctx context.Context, payment *models.Payment) (bool, error) {
+	result, err := bs.paymentProvider.GetPayment(ctx, payment.ProviderPaymentID)
+	if err != nil {
+		return false, err
+	}
+
+	status := models.PaymentStatus(result.Status)
+	changed := status != payment.Status
+	err = bs.paymentRepo.UpdateStatus(ctx, payment.ID, status, models.ProviderResponse{
+		Operation:  "get",
+		Body:       string(result.Raw),
+		ReceivedAt: time.Now(),
+	})
+	if err != nil {
+		return false, err
+	}
+	payment.Status = status
+
+	return changed, bs.applyPaymentStatus(ctx, payment)
+}
+
+// ReconcilePayments сверяет с провайдером платежи и возвраты без итогового статуса, созданные в [from, to),
+// на случай потерянных уведомлений и запросов, на которые провайдер не ответил
+func (bs *BookingService) ReconcilePayments(ctx context.Context, from, to time.Time) (*models.ReconcileReport, error) {
+	payments, err := bs.paymentRepo.FindNonFinal(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &models.ReconcileReport{}
+	for i := range payments {
+		if ctx.Err() != nil {
+			return report, ctx.Err()
+		}
+
+		report.Checked++
+		changed, err := bs.syncPayment(ctx, &payments[i])
+		if err != nil {
+			report.Failed++
+			log.Printf("reconcile payment %s: %v", payments[i].ProviderPaymentID, err)
+			continue
+		}
+		if changed {
+			report.Changed++
+		}
+	}
+
+	refunds, err := bs.refundRepo.FindPending(ctx, from, to)
+	if err != nil {
+		return report, err
+	}
+	for i := range refunds {
+		if ctx.Err() != nil {
+			return report, ctx.Err()
+		}
+
+		report.Checked++
+		changed, err := bs.syncRefund(ctx, &refunds[i])
+		if err != nil {
+			report.Failed++
+			log.Printf("reconcile refund %s: %v", refunds[i].ID.Hex(), err)
+			continue
+		}
+		if changed {
+			report.Changed++
+		}
+	}
+	return report, nil
+}
+
+func (bs *BookingService) ListPaymentReviews(ctx context.Context) ([]models.PaymentReview, error) {
+	return bs.reviewRepo.FindOpen(ctx)
+}
+
+func (bs *BookingService) applyPaymentStatus(ctx context.Context, payment *models.Payment) error {
+	if !payment.ModificationID.IsZero() {
+		return bs.applyModificationPayment(ctx, payment)
+	}
+
+	switch payment.Status {
+	case models.PaymentStatusSucceeded:
+		err := bs.ConfirmBooking(ctx, payment.BookingID.Hex(), payment.ProviderPaymentID)
+		var transitionErr *models.TransitionError
+		if errors.As(err, &transitionErr) || errors.Is(err, ErrReservationExpired) {
+			// Деньги списаны, а бронь уже не ждёт оплаты: автоматически это не исправить
+			return bs.flagForReview(ctx, payment, err.Error())
+		}
+		return err
+	case models.PaymentStatusCanceled:
+		return bs.paymentCanceled(ctx, payment)
+	case models.PaymentStatusWaitingForCapture:
+		return bs.capturePayment(ctx, payment)
+	}
+	return nil
+}
+
+func (bs *BookingService) flagForReview(ctx context.Context, payment *models.Payment, reason string) error {
+	booking, err := bs.bookingRepo.FindByID(ctx, payment.BookingID)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("payment %s flagged for review: %s", payment.ProviderPaymentID, reason)
+	return bs.reviewRepo.Add(ctx, &models.PaymentReview{
+		ID:                primitive.NewObjectID(),
+		PaymentID:         payment.ID,
+		ProviderPaymentID: payment.ProviderPaymentID,
+		BookingID:         booking.ID,
+		PaymentStatus:     payment.Status,
+		BookingStatus:     booking.Status,
+		Reason:            reason,
+		Status:            models.ReviewStatusOpen,
+		CreatedAt:         time.Now(),
+	})
+}
+
+// capturePayment списывает заблокированные средства, если бронь всё ещё ждёт этой оплаты и её места за ней.
+// Иначе блокировка снимается. Бронь подтверждается только после успешного списания.
+func (bs *BookingService) capturePayment(ctx context.Context, payment *models.Payment) error {
+	booking, err := bs.bookingRepo.FindByID(ctx, payment.BookingID)
+	if err != nil {
+		return err
+	}
+
+	if err := bs.verifyBeforeCapture(ctx, booking, payment); err != nil {
+		log.Printf("payment %s will not be captured: %v", payment.ProviderPaymentID, err)
+		return bs.voidPayment(ctx, payment)
+	}
+
+	result, err := bs.paymentProvider.CapturePayment(ctx, payment.ProviderPaymentID, money.New(payment.Amount, payment.Currency))
+	if err != nil {
+		return err
+	}
+
+	status := models.PaymentStatus(result.Status)
+	err = bs.paymentRepo.UpdateStatus(ctx, payment.ID, status, models.ProviderResponse{
+		Operation:  "capture",
+		Body:       string(result.Raw),
+		ReceivedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	payment.Status = status
+
+	if status != models.PaymentStatusSucceeded {
+		return nil
+	}
+	return bs.applyPaymentStatus(ctx, payment)
+}
+
+func (bs *BookingService) verifyBeforeCapture(ctx context.Context, booking *models.Booking, payment *models.Payment) error {
+	if booking.Status != models.BookingStatusPending || booking.PaymentID != payment.ProviderPaymentID {
+		return fmt.Errorf("booking %s is %s and not waiting for this payment", booking.ID.Hex(), booking.Status)
+	}
+
+	seats := 0
+	for _, ticket := range booking.Tickets {
+		seats += len(ticket.Seats)
+	}
+	if seats == 0 {
+		return nil
+	}
+
+	held, err := bs.seatRepo.CountByBooking(ctx, booking.ID, models.SeatStatusReserved)
+	if err != nil {
+		return err
+	}
+	if held != seats {
+		return fmt.Errorf("booking %s holds %d of %d seats", booking.ID.Hex(), held, seats)
+	}
+	return nil
+}
+
+// voidPayment снимает блокировку средств по двухстадийному платежу
+func (bs *BookingService) voidPayment(ctx context.Context, payment *models.Payment) error {
+	result, err := bs.paymentProvider.CancelPayment(ctx, payment.ProviderPaymentID)
+	if err != nil {
+		return err
+	}
+
+	payment.Status = models.PaymentStatus(result.Status)
+	return bs.paymentRepo.UpdateStatus(ctx, payment.ID, payment.Status, models.ProviderResponse{
+		Operation:  "cancel",
+		Body:       string(result.Raw),
+		ReceivedAt: time.Now(),
+	})
+}
+
+// voidOpenHolds снимает все действующие блокировки средств по брони после её отмены или истечения
+func (bs *BookingService) voidOpenHolds(ctx context.Context, bookingID primitive.ObjectID) {
+	payments, err := bs.paymentRepo.FindByBookingID(ctx, bookingID)
+	if err != nil {
+		log.Printf("find payments of booking %s: %v", bookingID.Hex(), err)
+		return
+	}
+
+	for i := range payments {
+		if payments[i].Status != models.PaymentStatusWaitingForCapture {
+			continue
+		}
+		if err := bs.voidPayment(ctx, &payments[i]); err != nil {
+			log.Printf("void payment %s: %v", payments[i].ProviderPaymentID, err)
+		}
+	}
+}
+
+// ExpirePendingPayment снимает бронь, оплата которой не завершилась до конца резерва. Сначала статус платежа
+// сверяется с провайдером: если оплата прошла, а уведомление потерялось, бронь подтверждается. Незавершённый платёж
+// отменяется, бронь переводится в expired, билеты и места возвращаются в продажу. Возвращает снятую бронь
+// или nil, если бронь уже обработана.
+func (bs *BookingService) ExpirePendingPayment(ctx context.Context, bookingID primitive.ObjectID) (*models.Booking, error) {
+	booking, err := bs.bookingRepo.FindByID(ctx, bookingID)
+	if err != nil {
+		return nil, err
+	}
+	if booking.Status != models.BookingStatusPending {
+		return nil, nil
+	}
+
+	payment, err := bs.paymentRepo.FindByProviderID(ctx, booking.PaymentID)
+	switch {
+	case errors.Is(err, repositories.ErrPaymentNotFound):
+	case err != nil:
+		return nil, err
+	default:
+		if _, err := bs.syncPayment(ctx, payment); err != nil {
+			return nil, err
+		}
+		if payment.Status == models.PaymentStatusPending {
+			// Если провайдер не даст отменить платёж и он всё же пройдёт, оплата уйдёт на ручную проверку
+			if err := bs.voidPayment(ctx, payment); err != nil {
+				log.Printf("cancel expired payment %s: %v", payment.ProviderPaymentID, err)
+			}
+		}
+	}
+
+	var expired *models.Booking
+	err = bs.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		expired = nil
+		current, err := bs.bookingRepo.FindByID(ctx, bookingID)
+		if err != nil {
+			return err
+		}
+		// После сверки бронь могла подтвердиться или вернуться в reserved
+		if current.Status != models.BookingStatusPending || current.PaymentID != booking.PaymentID {
+			return nil
+		}
+
+		reason := "payment " + booking.PaymentID + " not completed in time"
+		if err := bs.transition(ctx, current, models.BookingStatusExpired, reason, models.ActorSystem); err != nil {
+			return err
+		}
+		if err := bs.releaseTickets(ctx, current); err != nil {
+			return err
+		}
+		expired = current
+		return nil
+	})
+	if err != nil || expired == nil {
+		return nil, err
+	}
+
+	bs.voidOpenHolds(ctx, bookingID)
+	return expired, nil
+}
+
+// paymentCanceled возвращает бронь из pending в reserved, если резерв ещё действует, иначе снимает его
+func (bs *BookingService) paymentCanceled(ctx context.Context, payment *models.Payment) error {
+	return bs.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		booking, err := bs.bookingRepo.FindByID(ctx, payment.BookingID)
+		if err != nil {
+			return err
+		}
+
+		// Отмена старой попытки не должна трогать бронь, которую уже оплачивают заново
+		if booking.Status != models.BookingStatusPending || booking.PaymentID != payment.ProviderPaymentID {
+			return nil
+		}
+
+		reason := "payment " + payment.ProviderPaymentID + " canceled"
+		if time.Now().Before(booking.ReservedUntil) {
+			return bs.transition(ctx, booking, models.BookingStatusReserved, reason, models.ActorSystem)
+		}
+
+		if err := bs.transition(ctx, booking, models.BookingStatusExpired, reason, models.ActorSystem); err != nil {
+			return err
+		}
+		return bs.releaseTickets(ctx, booking)
+	})
+}
+
+// ProcessPaymentNotification сохраняет уведомление провайдера и применяет его. Повторно присланное
+// уже обработанное уведомление ничего не меняет. Ошибка обработки возвращается, чтобы провайдер повторил отправку.
+func (bs *BookingService) ProcessPaymentNotification(ctx context.Context, body []byte, remoteAddr string) error {
+	event, err := bs.paymentProvider.ParseWebhook(body)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidNotification, err)
+	}
+
+	now := time.Now()
+	err = bs.notificationRepo.Save(ctx, &models.PaymentNotification{
+		EventKey:   event.ID,
+		Event:      event.Event,
+		ObjectID:   event.ObjectID,
+		Body:       string(body),
+		RemoteAddr: remoteAddr,
+		Status:     models.NotificationStatusReceived,
+		ReceivedAt: now,
+		UpdatedAt:  now,
+	})
+	if err != nil {
+		return err
+	}
+
+	claimed, err := bs.notificationRepo.Claim(ctx, event.ID)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return nil
+	}
+
+	if err := bs.handlePaymentEvent(ctx, event); err != nil {
+		if markErr := bs.notificationRepo.MarkFailed(ctx, event.ID, err); markErr != nil {
+			log.Printf("mark notification %s failed: %v", event.ID, markErr)
+		}
+		return err
+	}
+
+	return bs.notificationRepo.MarkProcessed(ctx, event.ID)
+}
+
+func (bs *BookingService) handlePaymentEvent(ctx context.Context, event *WebhookEvent) error {
+	switch event.Event {
+	case "payment.succeeded", "payment.canceled", "payment.waiting_for_capture":
+		// Статус платежа берём у провайдера, а не из тела уведомления
+		return bs.SyncPayment(ctx, event.ObjectID)
+	case "refund.succeeded", "refund.canceled":
+		// Статус возврата тоже берём у провайдера
+		return bs.refundUpdated(ctx, event.ObjectID)
+	default:
+		log.Printf("payment webhook: unknown event %s for %s acknowledged", event.Event, event.ObjectID)
+		return nil
+	}
+}
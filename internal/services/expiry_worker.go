@@ -0,0 +1,180 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DrummDaddy/Booking_service/internal/repositories"
+)
+
+type ExpiryStats struct {
+	Expired int64 `json:"expired"`
+	// Released сколько билетов вернулось в продажу из просроченных броней
+	Released int64 `json:"released"`
+	Failed   int64 `json:"failed"`
+	// ExpiredPayments брони, оплата которых не завершилась до конца резерва
+	ExpiredPayments int64 `json:"expired_payments"`
+	// ExpiredModifications обмены билетов, отменённые без доплаты
+	ExpiredModifications int64 `json:"expired_modifications"`
+}
+
+type ExpiryWorker struct {
+	bookingRepo *repositories.BookingRepository
+	service     *BookingService
+	interval    time.Duration
+
+	expired  atomic.Int64
+	released atomic.Int64
+	failed   atomic.Int64
+
+	expiredPayments      atomic.Int64
+	expiredModifications atomic.Int64
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewExpiryWorker(
+	bookingRepo *repositories.BookingRepository,
+	service *BookingService,
+	interval time.Duration,
+) *ExpiryWorker {
+	return &ExpiryWorker{
+		bookingRepo: bookingRepo,
+		service:     service,
+		interval:    interval,
+	}
+}
+
+func (w *ExpiryWorker) Start(ctx context.Context) {
+	ctx, w.cancel = context.WithCancel(ctx)
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.run(ctx)
+	}()
+}
+
+// Stop дожидается окончания текущего прохода, чтобы не бросать бронь на полпути
+func (w *ExpiryWorker) Stop() {
+	if w.cancel != nil {
+		w.cancel()
+	}
+	w.wg.Wait()
+}
+
+func (w *ExpiryWorker) Stats() ExpiryStats {
+	return ExpiryStats{
+		Expired:  w.expired.Load(),
+		Released: w.released.Load(),
+		Failed:   w.failed.Load(),
+
+		ExpiredPayments:      w.expiredPayments.Load(),
+		ExpiredModifications: w.expiredModifications.Load(),
+	}
+}
+
+func (w *ExpiryWorker) run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		w.sweep(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *ExpiryWorker) sweep(ctx context.Context) {
+	bookings, err := w.bookingRepo.FindExpiredReservation(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("expiry worker: find expired reservations: %v", err)
+		}
+		return
+	}
+
+	for _, booking := range bookings {
+		if ctx.Err() != nil {
+			return
+		}
+
+		expired, err := w.service.ExpireReservation(ctx, booking.ID)
+		if err != nil {
+			w.failed.Add(1)
+			log.Printf("expiry worker: expire booking %s: %v", booking.ID.Hex(), err)
+			continue
+		}
+		if expired == nil {
+			continue
+		}
+		w.expired.Add(1)
+		for _, ticket := range expired.Tickets {
+			w.released.Add(int64(ticket.Quantity))
+		}
+	}
+
+	w.sweepPending(ctx)
+	w.sweepModifications(ctx)
+}
+
+func (w *ExpiryWorker) sweepPending(ctx context.Context) {
+	bookings, err := w.bookingRepo.FindExpiredPending(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("expiry worker: find expired payments: %v", err)
+		}
+		return
+	}
+
+	for _, booking := range bookings {
+		if ctx.Err() != nil {
+			return
+		}
+
+		expired, err := w.service.ExpirePendingPayment(ctx, booking.ID)
+		if err != nil {
+			w.failed.Add(1)
+			log.Printf("expiry worker: expire payment of booking %s: %v", booking.ID.Hex(), err)
+			continue
+		}
+		if expired == nil {
+			continue
+		}
+		w.expiredPayments.Add(1)
+		for _, ticket := range expired.Tickets {
+			w.released.Add(int64(ticket.Quantity))
+		}
+	}
+}
+
+func (w *ExpiryWorker) sweepModifications(ctx context.Context) {
+	bookings, err := w.bookingRepo.FindExpiredModifications(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("expiry worker: find expired ticket exchanges: %v", err)
+		}
+		return
+	}
+
+	for _, booking := range bookings {
+		if ctx.Err() != nil {
+			return
+		}
+
+		expired, err := w.service.ExpireModifications(ctx, &booking)
+		w.expiredModifications.Add(int64(expired))
+		if err != nil {
+			w.failed.Add(1)
+			log.Printf("expiry worker: expire ticket exchange of booking %s: %v", booking.ID.Hex(), err)
+		}
+	}
+}
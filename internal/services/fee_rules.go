@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/DrummDaddy/Booking_service/internal/models"
+	"github.com/DrummDaddy/Booking_service/internal/money"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// minServiceFees минимальный сервисный сбор за билет. Задан только для рублей: для других валют
+// минимум устанавливается политикой сборов мероприятия или организатора (min_per_ticket)
+var minServiceFees = map[string]money.Amount{
+	money.RUB: money.Minor(5000),
+}
+
+// defaultFeeRules действуют для мероприятий, у которых нет своей политики и политики организатора:
+// 10% от стоимости билетов, но не меньше минимального сбора за билет, если он задан для валюты брони
+var defaultFeeRules = []models.FeeRule{{
+	Name:         "service_fee",
+	Type:         models.FeeRulePercent,
+	BasisPoints:  1000,
+	MinPerTicket: minServiceFees,
+}}
+
+func (bs *BookingService) feeRules(ctx context.Context, event *models.Event) ([]models.FeeRule, error) {
+	policy, err := bs.feePolicyRepo.FindForEvent(ctx, event.ID, event.OrganizerID)
+	if err != nil {
+		return nil, err
+	}
+	if policy == nil {
+		return defaultFeeRules, nil
+	}
+	return policy.Rules, nil
+}
+
+// calculateFees применяет правила к билетам брони. Возвращает расшифровку по правилам
+// и сумму сборов, которую платит покупатель (без сборов за счёт организатора).
+func calculateFees(rules []models.FeeRule, tickets []models.BookingTicket, currency string) ([]models.AppliedFee, money.Amount, error) {
+	fees := make([]models.AppliedFee, 0, len(rules))
+	var buyerTotal money.Amount
+
+	for _, rule := range rules {
+		var (
+			amount  money.Amount
+			matched bool
+		)
+		for _, ticket := range tickets {
+			if !rule.AppliesTo(ticket.TicketTypeID) {
+				continue
+			}
+			matched = true
+
+			switch rule.Type {
+			case models.FeeRulePercent:
+				fee := ticket.TotalPrice.Percent(rule.BasisPoints, money.RoundHalfUp)
+				if minFee := rule.MinPerTicket[currency].Mul(ticket.Quantity); fee < minFee {
+					fee = minFee
+				}
+				amount += fee
+			case models.FeeRulePerTicket:
+				fixed, ok := rule.Amounts[currency]
+				if !ok {
+					return nil, 0, fmt.Errorf("fee rule %s has no amount in %s", rule.Name, currency)
+				}
+				amount += fixed.Mul(ticket.Quantity)
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		if rule.Type == models.FeeRulePerOrder {
+			fixed, ok := rule.Amounts[currency]
+			if !ok {
+				return nil, 0, fmt.Errorf("fee rule %s has no amount in %s", rule.Name, currency)
+			}
+			amount = fixed
+		}
+		if limit, ok := rule.Cap[currency]; ok && amount > limit {
+			amount = limit
+		}
+
+		fees = append(fees, models.AppliedFee{
+			Rule:     rule.Name,
+			Type:     rule.Type,
+			Amount:   amount,
+			Absorbed: rule.Absorbed,
+		})
+		if !rule.Absorbed {
+			buyerTotal += amount
+		}
+	}
+
+	return fees, buyerTotal, nil
+}
+
+// prorateFees уменьшает начисленные сборы пропорционально числу оставшихся билетов, но не увеличивает их.
+// Сбор за заказ сохраняется, пока в брони есть билеты.
+func prorateFees(base []models.AppliedFee, baseUnits int, tickets []models.BookingTicket) ([]models.AppliedFee, money.Amount) {
+	var units int
+	for _, ticket := range tickets {
+		units += ticket.Quantity
+	}
+
+	fees := make([]models.AppliedFee, 0, len(base))
+	var buyerTotal money.Amount
+	for _, fee := range base {
+		switch {
+		case units == 0 || baseUnits <= 0:
+			fee.Amount = 0
+		case fee.Type != models.FeeRulePerOrder && units < baseUnits:
+			fee.Amount = fee.Amount.MulRat(int64(units), int64(baseUnits), money.RoundDown)
+		}
+		fees = append(fees, fee)
+		if !fee.Absorbed {
+			buyerTotal += fee.Amount
+		}
+	}
+	return fees, buyerTotal
+}
+
+func validateFeeRules(rules []models.FeeRule) error {
+	names := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		if rule.Name == "" {
+			return errors.New("fee rule name is required")
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("duplicate fee rule %s", rule.Name)
+		}
+		names[rule.Name] = true
+
+		switch rule.Type {
+		case models.FeeRulePercent:
+			if rule.BasisPoints <= 0 || rule.BasisPoints > 10000 {
+				return fmt.Errorf("fee rule %s: basis_points must be between 1 and 10000", rule.Name)
+			}
+		case models.FeeRulePerTicket, models.FeeRulePerOrder:
+			if len(rule.Amounts) == 0 {
+				return fmt.Errorf("fee rule %s: amounts are required", rule.Name)
+			}
+		default:
+			return fmt.Errorf("fee rule %s: unknown type %q", rule.Name, rule.Type)
+		}
+
+		for _, amounts := range []map[string]money.Amount{rule.Amounts, rule.MinPerTicket, rule.Cap} {
+			for currency, amount := range amounts {
+				if !money.IsSupported(currency) {
+					return fmt.Errorf("fee rule %s: unsupported currency %s", rule.Name, currency)
+				}
+				if amount < 0 {
+					return fmt.Errorf("fee rule %s: negative amount", rule.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// GetEventFeeRules возвращает правила, которые будут применены к новым броням мероприятия
+func (bs *BookingService) GetEventFeeRules(ctx context.Context, eventID string) ([]models.FeeRule, error) {
+	eventObjID, err := primitive.ObjectIDFromHex(eventID)
+	if err != nil {
+		return nil, errors.New("invalid event ID format")
+	}
+	event, err := bs.eventRepo.FindByID(ctx, eventObjID)
+	if err != nil {
+		return nil, errors.New("event not found")
+	}
+	return bs.feeRules(ctx, event)
+}
+
+func (bs *BookingService) SetEventFeePolicy(ctx context.Context, eventID string, rules []models.FeeRule) (*models.FeePolicy, error) {
+	eventObjID, err := primitive.ObjectIDFromHex(eventID)
+	if err != nil {
+		return nil, errors.New("invalid event ID format")
+	}
+	if err := validateFeeRules(rules); err != nil {
+		return nil, err
+	}
+	return bs.feePolicyRepo.SaveForEvent(ctx, eventObjID, rules)
+}
+
+func (bs *BookingService) SetOrganizerFeePolicy(ctx context.Context, organizerID string, rules []models.FeeRule) (*models.FeePolicy, error) {
+	organizerObjID, err := primitive.ObjectIDFromHex(organizerID)
+	if err != nil {
+		return nil, errors.New("invalid organizer ID format")
+	}
+	if err := validateFeeRules(rules); err != nil {
+		return nil, err
+	}
+	return bs.feePolicyRepo.SaveForOrganizer(ctx, organizerObjID, rules)
+}
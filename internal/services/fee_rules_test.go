@@ -0,0 +1,166 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/DrummDaddy/Booking_service/internal/models"
+	"github.com/DrummDaddy/Booking_service/internal/money"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCalculateFees(t *testing.T) {
+	standard, vip := primitive.NewObjectID(), primitive.NewObjectID()
+	// Два билета по 1000.00 и один по 100.00
+	tickets := []models.BookingTicket{
+		{TicketTypeID: standard, Quantity: 2, TotalPrice: 200000},
+		{TicketTypeID: vip, Quantity: 1, TotalPrice: 10000},
+	}
+
+	tests := []struct {
+		name      string
+		rules     []models.FeeRule
+		currency  string
+		wantFees  []money.Amount
+		wantTotal money.Amount
+		wantErr   bool
+	}{
+		{
+			// 10% от 1000.00 за два билета и минимальные 50.00 вместо 10.00 за дешёвый билет
+			name:      "default rules with minimum",
+			rules:     defaultFeeRules,
+			currency:  money.RUB,
+			wantFees:  []money.Amount{25000},
+			wantTotal: 25000,
+		},
+		{
+			name:      "default rules without minimum for currency",
+			rules:     defaultFeeRules,
+			currency:  money.USD,
+			wantFees:  []money.Amount{21000},
+			wantTotal: 21000,
+		},
+		{
+			name: "percent capped",
+			rules: []models.FeeRule{{
+				Name: "service_fee", Type: models.FeeRulePercent, BasisPoints: 1000,
+				Cap: map[string]money.Amount{money.RUB: 15000},
+			}},
+			currency:  money.RUB,
+			wantFees:  []money.Amount{15000},
+			wantTotal: 15000,
+		},
+		{
+			name: "per ticket for one ticket type",
+			rules: []models.FeeRule{{
+				Name: "vip_fee", Type: models.FeeRulePerTicket, TicketTypeIDs: []primitive.ObjectID{vip},
+				Amounts: map[string]money.Amount{money.RUB: 3000},
+			}},
+			currency:  money.RUB,
+			wantFees:  []money.Amount{3000},
+			wantTotal: 3000,
+		},
+		{
+			name: "per order charged once",
+			rules: []models.FeeRule{{
+				Name: "order_fee", Type: models.FeeRulePerOrder,
+				Amounts: map[string]money.Amount{money.RUB: 5000},
+			}},
+			currency:  money.RUB,
+			wantFees:  []money.Amount{5000},
+			wantTotal: 5000,
+		},
+		{
+			name: "rule for missing ticket type skipped",
+			rules: []models.FeeRule{{
+				Name: "order_fee", Type: models.FeeRulePerOrder, TicketTypeIDs: []primitive.ObjectID{primitive.NewObjectID()},
+				Amounts: map[string]money.Amount{money.RUB: 5000},
+			}},
+			currency:  money.RUB,
+			wantFees:  []money.Amount{},
+			wantTotal: 0,
+		},
+		{
+			name: "absorbed fee not paid by buyer",
+			rules: []models.FeeRule{
+				{Name: "order_fee", Type: models.FeeRulePerOrder, Amounts: map[string]money.Amount{money.RUB: 5000}},
+				{Name: "organizer_fee", Type: models.FeeRulePerTicket, Amounts: map[string]money.Amount{money.RUB: 1000}, Absorbed: true},
+			},
+			currency:  money.RUB,
+			wantFees:  []money.Amount{5000, 3000},
+			wantTotal: 5000,
+		},
+		{
+			name: "no amount in booking currency",
+			rules: []models.FeeRule{{
+				Name: "order_fee", Type: models.FeeRulePerOrder,
+				Amounts: map[string]money.Amount{money.RUB: 5000},
+			}},
+			currency: money.EUR,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fees, total, err := calculateFees(tt.rules, tickets, tt.currency)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got fees %v", fees)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if total != tt.wantTotal {
+				t.Errorf("total = %s, want %s", total, tt.wantTotal)
+			}
+			if len(fees) != len(tt.wantFees) {
+				t.Fatalf("got %d fees, want %d", len(fees), len(tt.wantFees))
+			}
+			for i, fee := range fees {
+				if fee.Amount != tt.wantFees[i] {
+					t.Errorf("%s = %s, want %s", fee.Rule, fee.Amount, tt.wantFees[i])
+				}
+			}
+		})
+	}
+}
+
+func TestProrateFees(t *testing.T) {
+	base := []models.AppliedFee{
+		{Rule: "service_fee", Type: models.FeeRulePercent, Amount: 10000},
+		{Rule: "order_fee", Type: models.FeeRulePerOrder, Amount: 5000},
+		{Rule: "organizer_fee", Type: models.FeeRulePerTicket, Amount: 3000, Absorbed: true},
+	}
+	tests := []struct {
+		name      string
+		units     []int
+		wantFees  []money.Amount
+		wantTotal money.Amount
+	}{
+		{name: "unchanged", units: []int{2, 1}, wantFees: []money.Amount{10000, 5000, 3000}, wantTotal: 15000},
+		{name: "one of three", units: []int{1}, wantFees: []money.Amount{3333, 5000, 1000}, wantTotal: 8333},
+		{name: "two of three", units: []int{1, 1}, wantFees: []money.Amount{6666, 5000, 2000}, wantTotal: 11666},
+		// Обмен на большее число билетов не увеличивает сбор
+		{name: "more tickets", units: []int{4}, wantFees: []money.Amount{10000, 5000, 3000}, wantTotal: 15000},
+		{name: "no tickets", units: nil, wantFees: []money.Amount{0, 0, 0}, wantTotal: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tickets []models.BookingTicket
+			for _, quantity := range tt.units {
+				tickets = append(tickets, models.BookingTicket{Quantity: quantity})
+			}
+
+			fees, total := prorateFees(base, 3, tickets)
+			if total != tt.wantTotal {
+				t.Errorf("total = %s, want %s", total, tt.wantTotal)
+			}
+			for i, fee := range fees {
+				if fee.Amount != tt.wantFees[i] {
+					t.Errorf("%s = %s, want %s", fee.Rule, fee.Amount, tt.wantFees[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var (
+	ErrIdempotencyKeyReused    = errors.New("idempotency key was already used with a different request")
+	ErrIdempotencyKeyInProcess = errors.New("request with this idempotency key is still in progress")
+	ErrIdempotencyClaimLost    = errors.New("idempotency key is no longer held by this request")
+)
+
+type IdempotentResponse struct {
+	Fingerprint string `json:"fingerprint"`
+	// Token метка запроса, занявшего ключ: Complete и Abort другого запроса её не тронут
+	Token       string `json:"token,omitempty"`
+	Completed   bool   `json:"completed"`
+	StatusCode  int    `json:"status_code,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+type IdempotencyStore struct {
+	cache *RedisCache
+	ttl   time.Duration
+	// Время жизни метки выполняемого запроса: если процесс упадёт, не вызвав Complete или Abort,
+	// ключ освободится сам и клиент сможет повторить запрос. Запрос не должен выполняться дольше, см. RequestTimeout.
+	pendingTTL time.Duration
+}
+
+// IdempotencyClaim ключ, занятый запросом в Begin
+type IdempotencyClaim struct {
+	key     string
+	pending []byte
+}
+
+func NewIdempotencyStore(cache *RedisCache, ttl, pendingTTL time.Duration) *IdempotencyStore {
+	return &IdempotencyStore{
+		cache:      cache,
+		ttl:        ttl,
+		pendingTTL: pendingTTL,
+	}
+}
+
+// RequestTimeout сколько может выполняться запрос под ключом. Берётся с запасом от pendingTTL,
+// чтобы метка не истекла раньше, чем запрос закончится.
+func (s *IdempotencyStore) RequestTimeout() time.Duration {
+	return s.pendingTTL / 2
+}
+
+// Begin занимает ключ за запросом. Если ключ уже использован тем же запросом и ответ сохранён,
+// возвращает сохранённый ответ. Иначе возвращает claim: запрос нужно выполнить и вызвать Complete или Abort.
+func (s *IdempotencyStore) Begin(ctx context.Context, key, fingerprint string) (*IdempotentResponse, *IdempotencyClaim, error) {
+	pending, err := json.Marshal(IdempotentResponse{Fingerprint: fingerprint, Token: randomHex(16)})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	claimed, err := s.cache.SetNX(ctx, key, pending, s.pendingTTL)
+	if err != nil {
+		return nil, nil, err
+	}
+	if claimed {
+		return nil, &IdempotencyClaim{key: key, pending: pending}, nil
+	}
+
+	raw, found, err := s.cache.Get(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !found {
+		// Ключ истёк между SetNX и Get
+		return s.Begin(ctx, key, fingerprint)
+	}
+
+	var stored IdempotentResponse
+	if err := json.Unmarshal(raw, &stored); err != nil {
+		return nil, nil, err
+	}
+	if stored.Fingerprint != fingerprint {
+		return nil, nil, ErrIdempotencyKeyReused
+	}
+	if !stored.Completed {
+		return nil, nil, ErrIdempotencyKeyInProcess
+	}
+
+	return &stored, nil, nil
+}
+
+// Complete сохраняет ответ, если ключ всё ещё занят этим запросом. Если метка истекла и ключ занял
+// другой запрос, его ответ не перезаписываем и возвращаем ErrIdempotencyClaimLost.
+func (s *IdempotencyStore) Complete(ctx context.Context, claim *IdempotencyClaim, resp *IdempotentResponse) error {
+	resp.Completed = true
+	resp.Token = ""
+
+	raw, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	ok, err := s.cache.CompareAndSet(ctx, claim.key, claim.pending, raw, s.ttl)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrIdempotencyClaimLost
+	}
+	return nil
+}
+
+// Abort освобождает ключ, чтобы запрос можно было повторить (например, после внутренней ошибки).
+// Ключ, который уже занял другой запрос, не трогает.
+func (s *IdempotencyStore) Abort(ctx context.Context, claim *IdempotencyClaim) error {
+	_, err := s.cache.CompareAndDelete(ctx, claim.key, claim.pending)
+	return err
+}
@@ -0,0 +1,443 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/DrummDaddy/Booking_service/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var errModificationClosed = errors.New("ticket exchange is no longer waiting for payment")
+
+// ModifyBooking меняет билеты строки брони на другой тип или другие места с сохранением ID брони.
+// Новые билеты резервируются, а старые освобождаются в одной транзакции. В неоплаченной брони
+// меняется только сумма к оплате. По оплаченной брони разница в пользу покупателя возвращается сразу,
+// а при доплате новые билеты ждут оплаты в статусе pending и обмен завершается после её успеха.
+func (bs *BookingService) ModifyBooking(ctx context.Context, bookingID, userID string, req models.ModificationRequest) (*models.BookingModification, error) {
+	booking, err := bs.Getbooking(ctx, bookingID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch booking.Status {
+	case models.BookingStatusReserved:
+		if time.Now().After(booking.ReservedUntil) {
+			return nil, ErrReservationExpired
+		}
+	case models.BookingStatusConfirmed:
+		if booking.PendingModification() != nil {
+			return nil, errors.New("booking already has a ticket exchange waiting for payment")
+		}
+	default:
+		return nil, fmt.Errorf("tickets of %s booking cannot be exchanged", booking.Status)
+	}
+
+	plan, err := bs.planBookingChange(ctx, booking)
+	if err != nil {
+		return nil, err
+	}
+	newType, err := findTicketType(plan.event, req.TicketID)
+	if err != nil {
+		return nil, err
+	}
+	line := models.TicketLineRequest{TicketIndex: req.TicketIndex, Quantity: req.Quantity, Seats: req.Seats}
+	ticket, seats, err := selectTicketLine(booking.Tickets, line, map[int]bool{})
+	if err != nil {
+		return nil, err
+	}
+	if newType.ID == ticket.TicketTypeID && !newType.AssignedSeating {
+		return nil, errors.New("tickets are already of this type")
+	}
+
+	now := time.Now()
+	var modification models.BookingModification
+	var refunds []*models.Refund
+	err = bs.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		// Транзакция может повториться, поэтому бронь меняется только в копии
+		current := *booking
+		modification = models.BookingModification{
+			ID:           primitive.NewObjectID(),
+			TicketIndex:  req.TicketIndex,
+			TicketTypeID: ticket.TicketTypeID,
+			Quantity:     req.Quantity,
+			Seats:        seats,
+			Actor:        models.UserActor(userID),
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		}
+
+		selection := models.TicketSelection{TicketID: req.TicketID, Quantity: req.Quantity, Seats: req.NewSeats}
+		newTickets, err := bs.reserveTickets(ctx, plan.event, current.ID, current.Currency, []models.TicketSelection{selection})
+		if err != nil {
+			return err
+		}
+		modification.Tickets = newTickets
+
+		if err := bs.priceModification(plan, current.Tickets, &modification); err != nil {
+			return err
+		}
+
+		if current.Status == models.BookingStatusReserved || modification.PriceDifference <= 0 {
+			refunds, err = bs.completeModification(ctx, &current, plan.event, &modification, booking.UpdatedAt)
+			return err
+		}
+
+		modification.Status = models.ModificationStatusPending
+		modification.ExpiresAt = plan.event.HoldPolicy().TopUpDeadline(now)
+		current.Modifications = append(current.Modifications, modification)
+		current.UpdatedAt = now
+		return bs.bookingRepo.UpdateTickets(ctx, &current, booking.UpdatedAt)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if modification.Status == models.ModificationStatusPending {
+		customer := models.ReceiptCustomer{Email: req.Email, Phone: req.Phone}
+		url, err := bs.createTopUpPayment(ctx, booking, plan.event, &modification, req.ReturnURL, customer)
+		if err != nil {
+			if cancelErr := bs.cancelModification(ctx, booking.ID, modification.ID); cancelErr != nil {
+				log.Printf("cancel ticket exchange %s: %v", modification.ID.Hex(), cancelErr)
+			}
+			return nil, err
+		}
+		modification.ConfirmationURL = url
+		return &modification, nil
+	}
+
+	if len(refunds) > 0 {
+		if _, err := bs.sendRefunds(ctx, refunds); err != nil {
+			log.Printf("refund price difference of ticket exchange %s: %v", modification.ID.Hex(), err)
+		}
+	}
+	return &modification, nil
+}
+
+// priceModification считает изменение сумм брони при обмене. Разница считается по действующим
+// (не возвращённым) билетам до и после обмена, сбор по правилам, сохранённым в брони (planBookingChange).
+func (bs *BookingService) priceModification(plan *bookingPlan, tickets []models.BookingTicket, modification *models.BookingModification) error {
+	before, err := bs.calculateTotals(plan, activeTickets(tickets))
+	if err != nil {
+		return err
+	}
+	after, err := bs.calculateTotals(plan, activeTickets(exchangeTickets(tickets, modification)))
+	if err != nil {
+		return err
+	}
+	modification.SubtotalChange = after.subtotal - before.subtotal
+	modification.DiscountChange = after.discount - before.discount
+	modification.ServiceFeeChange = after.serviceFee - before.serviceFee
+	modification.PriceDifference = after.total - before.total
+	modification.Fees = after.fees
+	return nil
+}
+
+// completeModification применяет обмен к брони: строки, суммы, остатки билетов и мест.
+// Для оплаченной брони с разницей в пользу покупателя возвращает созданные возвраты, их нужно отправить
+// провайдеру после транзакции. Вызывается в транзакции.
+func (bs *BookingService) completeModification(ctx context.Context, booking *models.Booking, event *models.Event, modification *models.BookingModification, prevUpdatedAt time.Time) ([]*models.Refund, error) {
+	if modification.TicketIndex >= len(booking.Tickets) || booking.Tickets[modification.TicketIndex].ActiveQuantity() < modification.Quantity {
+		return nil, errors.New("exchanged tickets are no longer in the booking")
+	}
+	wasSold := booking.Status == models.BookingStatusConfirmed
+
+	var refunds []*models.Refund
+	if wasSold && modification.PriceDifference < 0 {
+		var err error
+		refunds, err = bs.splitRefund(ctx, booking, event, &models.Refund{
+			BookingID:      booking.ID,
+			ModificationID: modification.ID,
+			Amount:         -modification.PriceDifference,
+			Currency:       booking.Currency,
+			Reason:         "ticket exchange",
+			Actor:          modification.Actor,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, refund := range refunds {
+			modification.RefundIDs = append(modification.RefundIDs, refund.ID)
+		}
+	}
+
+	now := time.Now()
+	booking.Tickets = exchangeTickets(booking.Tickets, modification)
+	if !wasSold {
+		// В неоплаченной брони нет возвратов, которые ссылаются на номера строк
+		var tickets []models.BookingTicket
+		for _, ticket := range booking.Tickets {
+			if ticket.Quantity > 0 {
+				tickets = append(tickets, ticket)
+			}
+		}
+		booking.Tickets = tickets
+	}
+	booking.Subtotal += modification.SubtotalChange
+	booking.Discount += modification.DiscountChange
+	booking.ServiceFree += modification.ServiceFeeChange
+	booking.TotalAmount += modification.PriceDifference
+	booking.Fees = modification.Fees
+	modification.Status = models.ModificationStatusCompleted
+	modification.UpdatedAt = now
+	if existing := booking.Modification(modification.ID); existing != nil {
+		*existing = *modification
+	} else {
+		booking.Modifications = append(booking.Modifications, *modification)
+	}
+	booking.UpdatedAt = now
+	if err := bs.bookingRepo.UpdateTickets(ctx, booking, prevUpdatedAt); err != nil {
+		return nil, err
+	}
+
+	if wasSold {
+		if err := bs.ticketRepo.ReturnSoldTickets(ctx, booking.EventID, modification.TicketTypeID, modification.Quantity); err != nil {
+			return nil, err
+		}
+		for _, ticket := range modification.Tickets {
+			if err := bs.ticketRepo.ConfirmSale(ctx, booking.EventID, ticket.TicketTypeID, ticket.Quantity); err != nil {
+				return nil, err
+			}
+		}
+		if err := bs.seatRepo.ConfirmSeats(ctx, booking.ID); err != nil {
+			return nil, err
+		}
+	} else if err := bs.ticketRepo.ReleaseTickets(ctx, booking.EventID, modification.TicketTypeID, modification.Quantity); err != nil {
+		return nil, err
+	}
+	if len(modification.Seats) > 0 {
+		seatIDs, err := seatObjectIDs(modification.Seats)
+		if err != nil {
+			return nil, err
+		}
+		if err := bs.seatRepo.ReleaseSeatIDs(ctx, booking.ID, seatIDs); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, refund := range refunds {
+		if err := bs.refundRepo.Create(ctx, refund); err != nil {
+			return nil, err
+		}
+	}
+	return refunds, nil
+}
+
+// cancelModification освобождает билеты и места, зарезервированные под обмен, который не оплатили
+func (bs *BookingService) cancelModification(ctx context.Context, bookingID, modificationID primitive.ObjectID) error {
+	return bs.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		booking, err := bs.bookingRepo.FindByID(ctx, bookingID)
+		if err != nil {
+			return err
+		}
+		modification := booking.Modification(modificationID)
+		if modification == nil || modification.Status != models.ModificationStatusPending {
+			return nil
+		}
+
+		var seats []string
+		for _, ticket := range modification.Tickets {
+			if err := bs.ticketRepo.ReleaseTickets(ctx, booking.EventID, ticket.TicketTypeID, ticket.Quantity); err != nil {
+				return err
+			}
+			for _, seat := range ticket.Seats {
+				seats = append(seats, seat.SeatID)
+			}
+		}
+		if len(seats) > 0 {
+			seatIDs, err := seatObjectIDs(seats)
+			if err != nil {
+				return err
+			}
+			if err := bs.seatRepo.ReleaseSeatIDs(ctx, booking.ID, seatIDs); err != nil {
+				return err
+			}
+		}
+
+		prevUpdatedAt := booking.UpdatedAt
+		modification.Status = models.ModificationStatusCanceled
+		modification.UpdatedAt = time.Now()
+		booking.UpdatedAt = modification.UpdatedAt
+		return bs.bookingRepo.UpdateTickets(ctx, booking, prevUpdatedAt)
+	})
+}
+
+// ExpireModifications отменяет обмены брони, не дождавшиеся доплаты: платёж доплаты отменяется у провайдера,
+// новые билеты и места освобождаются. Возвращает число отменённых обменов.
+func (bs *BookingService) ExpireModifications(ctx context.Context, booking *models.Booking) (int, error) {
+	expired := 0
+	for _, modification := range booking.ExpiredModifications(time.Now()) {
+		if modification.PaymentID != "" {
+			payment, err := bs.paymentRepo.FindByProviderID(ctx, modification.PaymentID)
+			if err != nil {
+				return expired, err
+			}
+			if err := bs.voidPayment(ctx, payment); err != nil {
+				log.Printf("cancel top-up payment %s: %v", payment.ProviderPaymentID, err)
+				// Доплата могла пройти до отмены, тогда обмен завершается по статусу платежа
+				if _, err := bs.syncPayment(ctx, payment); err != nil {
+					return expired, err
+				}
+				if payment.Status == models.PaymentStatusSucceeded {
+					continue
+				}
+			}
+		}
+
+		// Если платёж пройдёт уже после отмены обмена, он попадёт на ручную проверку (applyModificationPayment)
+		if err := bs.cancelModification(ctx, booking.ID, modification.ID); err != nil {
+			return expired, err
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// applyModificationPayment применяет статус платежа доплаты за обмен
+func (bs *BookingService) applyModificationPayment(ctx context.Context, payment *models.Payment) error {
+	switch payment.Status {
+	case models.PaymentStatusSucceeded:
+		err := bs.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+			booking, err := bs.bookingRepo.FindByID(ctx, payment.BookingID)
+			if err != nil {
+				return err
+			}
+			modification := booking.Modification(payment.ModificationID)
+			if modification == nil {
+				return errModificationClosed
+			}
+			// Повтор уведомления об уже применённой доплате
+			if modification.Status == models.ModificationStatusCompleted {
+				return nil
+			}
+			if modification.Status != models.ModificationStatusPending || booking.Status != models.BookingStatusConfirmed {
+				return errModificationClosed
+			}
+			event, err := bs.eventRepo.FindByID(ctx, booking.EventID)
+			if err != nil {
+				return errors.New("event not found")
+			}
+
+			_, err = bs.completeModification(ctx, booking, event, modification, booking.UpdatedAt)
+			return err
+		})
+		if errors.Is(err, errModificationClosed) {
+			// Доплата списана, а обмен уже не применить: билеты освобождаются, деньги возвращает оператор
+			if cancelErr := bs.cancelModification(ctx, payment.BookingID, payment.ModificationID); cancelErr != nil {
+				log.Printf("cancel ticket exchange %s: %v", payment.ModificationID.Hex(), cancelErr)
+			}
+			return bs.flagForReview(ctx, payment, err.Error())
+		}
+		return err
+	case models.PaymentStatusCanceled:
+		return bs.cancelModification(ctx, payment.BookingID, payment.ModificationID)
+	}
+	return nil
+}
+
+// createTopUpPayment создаёт платёж доплаты за обмен. Чек отправляется на контакты из запроса,
+// а если их нет, на контакты из чека основного платежа.
+func (bs *BookingService) createTopUpPayment(ctx context.Context, booking *models.Booking, event *models.Event, modification *models.BookingModification, returnURL string, customer models.ReceiptCustomer) (string, error) {
+	var receipt *models.Receipt
+	if receiptRequired(booking.Currency) {
+		if customer.Email == "" && customer.Phone == "" {
+			if main, err := bs.paymentRepo.FindByProviderID(ctx, booking.PaymentID); err == nil && main.Receipt != nil {
+				customer = main.Receipt.Customer
+			}
+		}
+		customer, err := normalizeReceiptCustomer(customer)
+		if err != nil {
+			return "", err
+		}
+		settings := event.FiscalSettings()
+		receipt = &models.Receipt{
+			Customer:      customer,
+			Items:         receiptItems("Доплата за обмен билетов: "+event.Name, modification.PriceDifference, 1, booking.Currency, settings.VatCode, settings),
+			TaxSystemCode: settings.TaxSystemCode,
+		}
+	}
+
+	payment := &models.Payment{
+		ID:             primitive.NewObjectID(),
+		BookingID:      booking.ID,
+		ModificationID: modification.ID,
+		Amount:         modification.PriceDifference,
+		Currency:       booking.Currency,
+		Receipt:        receipt,
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	}
+
+	// Доплата списывается сразу: двухстадийная проверка рассчитана на бронь в pending
+	result, err := bs.paymentProvider.CreatePayment(ctx, PaymentRequest{
+		OrderID:        booking.ID.Hex(),
+		Amount:         payment.Amount,
+		Currency:       payment.Currency,
+		Description:    "Доплата за обмен билетов",
+		ReturnURL:      returnURL,
+		IdempotenceKey: payment.ID.Hex(),
+		Capture:        true,
+		Receipt:        receipt,
+	})
+	if err != nil {
+		payment.Status = models.PaymentStatusFailed
+		payment.Error = err.Error()
+		if err := bs.paymentRepo.Create(ctx, payment); err != nil {
+			log.Printf("save failed payment for booking %s: %v", booking.ID.Hex(), err)
+		}
+		return "", err
+	}
+
+	payment.ProviderPaymentID = result.ID
+	payment.Status = models.PaymentStatus(result.Status)
+	payment.ConfirmationURL = result.ConfirmationURL
+	payment.ProviderResponses = []models.ProviderResponse{{
+		Operation:  "create",
+		Body:       string(result.Raw),
+		ReceivedAt: time.Now(),
+	}}
+
+	err = bs.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := bs.paymentRepo.Create(ctx, payment); err != nil {
+			return err
+		}
+		return bs.bookingRepo.SetModificationPayment(ctx, booking.ID, modification.ID, result.ID)
+	})
+	if err != nil {
+		return "", err
+	}
+	modification.PaymentID = result.ID
+	return payment.ConfirmationURL, nil
+}
+
+// activeTickets строки брони только с действующими (не возвращёнными) билетами
+func activeTickets(tickets []models.BookingTicket) []models.BookingTicket {
+	active := make([]models.BookingTicket, 0, len(tickets))
+	for _, ticket := range tickets {
+		quantity := ticket.ActiveQuantity()
+		if quantity == 0 {
+			continue
+		}
+		ticket.Seats = ticket.ActiveSeats()
+		ticket.Quantity = quantity
+		ticket.TotalPrice = ticket.UnitPrice.Mul(quantity)
+		ticket.RefundedQuantity = 0
+		ticket.RefundedSeats = nil
+		active = append(active, ticket)
+	}
+	return active
+}
+
+// exchangeTickets строки брони после обмена: сданные билеты убираются из строки, новые добавляются в конец,
+// чтобы номера существующих строк не менялись
+func exchangeTickets(tickets []models.BookingTicket, modification *models.BookingModification) []models.BookingTicket {
+	result := append([]models.BookingTicket(nil), tickets...)
+	line := &result[modification.TicketIndex]
+	line.Quantity -= modification.Quantity
+	line.TotalPrice = line.UnitPrice.Mul(line.Quantity)
+	line.Seats = withoutSeats(line.Seats, modification.Seats)
+	return append(result, modification.Tickets...)
+}
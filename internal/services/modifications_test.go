@@ -0,0 +1,68 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/DrummDaddy/Booking_service/internal/models"
+	"github.com/DrummDaddy/Booking_service/internal/money"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPriceModification(t *testing.T) {
+	standard, vip := primitive.NewObjectID(), primitive.NewObjectID()
+	tenPercent := []models.FeeRule{{Name: "service_fee", Type: models.FeeRulePercent, BasisPoints: 1000}}
+
+	// Бронь на два стандартных билета по 1000.00 со сбором 10%, один билет меняется на VIP за 2000.00
+	tickets := []models.BookingTicket{{TicketTypeID: standard, Quantity: 2, UnitPrice: 100000, TotalPrice: 200000}}
+	exchange := models.BookingModification{
+		TicketIndex:  0,
+		TicketTypeID: standard,
+		Quantity:     1,
+		Tickets:      []models.BookingTicket{{TicketTypeID: vip, Quantity: 1, UnitPrice: 200000, TotalPrice: 200000}},
+	}
+
+	tests := []struct {
+		name           string
+		plan           bookingPlan
+		tickets        []models.BookingTicket
+		wantDifference money.Amount
+		wantFeeChange  money.Amount
+	}{
+		{
+			name:           "frozen rules",
+			plan:           bookingPlan{currency: money.RUB, feeRules: tenPercent},
+			tickets:        tickets,
+			wantDifference: 110000,
+			wantFeeChange:  10000,
+		},
+		{
+			// Сбор брони без сохранённых правил при обмене не растёт
+			name:           "legacy booking",
+			plan:           bookingPlan{currency: money.RUB, prorateFees: true, baseFees: []models.AppliedFee{{Rule: "service_fee", Type: models.FeeRulePercent, Amount: 20000}}, baseUnits: 2},
+			tickets:        tickets,
+			wantDifference: 100000,
+			wantFeeChange:  0,
+		},
+		{
+			name: "after refund of one ticket",
+			plan: bookingPlan{currency: money.RUB, feeRules: tenPercent},
+			tickets: []models.BookingTicket{{
+				TicketTypeID: standard, Quantity: 3, UnitPrice: 100000, TotalPrice: 300000, RefundedQuantity: 1,
+			}},
+			wantDifference: 110000,
+			wantFeeChange:  10000,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			modification := exchange
+			bs := &BookingService{}
+			if err := bs.priceModification(&tt.plan, tt.tickets, &modification); err != nil {
+				t.Fatal(err)
+			}
+			if modification.PriceDifference != tt.wantDifference || modification.ServiceFeeChange != tt.wantFeeChange {
+				t.Errorf("difference %s (fee %s), want %s (fee %s)", modification.PriceDifference, modification.ServiceFeeChange, tt.wantDifference, tt.wantFeeChange)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/DrummDaddy/Booking_service/internal/models"
+	"github.com/DrummDaddy/Booking_service/internal/money"
+)
+
+// PaymentProvider описывает платёжный шлюз. BookingService работает только через этот интерфейс,
+// поэтому вместо реального шлюза можно подставить SandboxProvider.
+type PaymentProvider interface {
+	CreatePayment(ctx context.Context, req PaymentRequest) (*PaymentResult, error)
+	GetPayment(ctx context.Context, paymentID string) (*PaymentResult, error)
+	CapturePayment(ctx context.Context, paymentID string, amount money.Money) (*PaymentResult, error)
+	CancelPayment(ctx context.Context, paymentID string) (*PaymentResult, error)
+	CreateRefund(ctx context.Context, req RefundRequest) (*RefundResult, error)
+	GetRefund(ctx context.Context, refundID string) (*RefundResult, error)
+	ParseWebhook(body []byte) (*WebhookEvent, error)
+}
+
+type PaymentRequest struct {
+	OrderID        string
+	Amount         money.Amount
+	Currency       string
+	Description    string
+	ReturnURL      string
+	IdempotenceKey string
+	// Capture false создаёт платёж с блокировкой средств, списание через CapturePayment
+	Capture bool
+	// Receipt чек по 54-ФЗ, nil если чек не нужен
+	Receipt *models.Receipt
+}
+
+type PaymentResult struct {
+	ID              string
+	Status          string
+	ConfirmationURL string
+	Raw             []byte
+}
+
+type RefundRequest struct {
+	PaymentID      string
+	Amount         money.Amount
+	Currency       string
+	Description    string
+	IdempotenceKey string
+	Receipt        *models.Receipt
+}
+
+type RefundResult struct {
+	ID        string
+	PaymentID string
+	Status    string
+	Raw       []byte
+}
+
+// ProviderError ответ провайдера с кодом ошибки
+type ProviderError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *ProviderError) Error() string {
+	return fmt.Sprintf("payment provider returned %d: %s", e.StatusCode, e.Body)
+}
+
+// isProviderRejection провайдер точно не принял запрос (4xx). При сетевой ошибке или 5xx
+// запрос мог быть выполнен, и его нужно повторить с тем же ключом идемпотентности.
+func isProviderRejection(err error) bool {
+	var providerErr *ProviderError
+	return errors.As(err, &providerErr) && providerErr.StatusCode >= 400 && providerErr.StatusCode < 500
+}
+
+type WebhookEvent struct {
+	// ID ключ события для дедупликации повторно присланных уведомлений
+	ID       string
+	Event    string
+	ObjectID string
+	Status   string
+	Raw      []byte
+}
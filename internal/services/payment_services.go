@@ -2,105 +2,219 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
+	"time"
+
+	"github.com/DrummDaddy/Booking_service/internal/money"
 )
 
+var ErrInvalidNotification = errors.New("invalid notification format")
+
+// PaymentService клиент API платежей в формате YooKassa (/v3/payments, /v3/refunds)
 type PaymentService struct {
 	APIURL string `json:"apiurl,omitempty"`
 	APIKey string `json:"api_key,omitempty"`
+
+	client *http.Client
 }
 
-func NewPaymentService(apiUrl, apiKey string) *PaymentService {
+var _ PaymentProvider = (*PaymentService)(nil)
+
+// NewPaymentService timeout ограничивает каждый запрос к провайдеру, чтобы зависшее соединение
+// не держало транзакцию брони и ключ идемпотентности
+func NewPaymentService(apiUrl, apiKey string, timeout time.Duration) *PaymentService {
 	return &PaymentService{
 		APIURL: apiUrl,
 		APIKey: apiKey,
+		client: &http.Client{Timeout: timeout},
 	}
 }
 
-func (ps *PaymentService) CreatePayment(ordeID string, amount float64, currency, description, returnURL string) (string, error) {
+func (ps *PaymentService) CreatePayment(ctx context.Context, paymentReq PaymentRequest) (*PaymentResult, error) {
 	requestBody := map[string]interface{}{
-		"amount": map[string]interface{}{
-			"value":    amount,
-			"currency": currency,
-		},
-		"capture":     true,
-		"description": description,
+		"amount":      money.New(paymentReq.Amount, paymentReq.Currency),
+		"capture":     paymentReq.Capture,
+		"description": paymentReq.Description,
 		"confirmation": map[string]string{
 			"type":       "redirect",
-			"return_url": returnURL,
+			"return_url": paymentReq.ReturnURL,
 		},
 		"metadata": map[string]string{
-			"order_id": ordeID,
+			"order_id": paymentReq.OrderID,
 		},
 	}
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		return "", err
+	if paymentReq.Receipt != nil {
+		requestBody["receipt"] = paymentReq.Receipt
 	}
 
-	req, err := http.NewRequest("POST", ps.APIURL+"/v3/payments", bytes.NewBuffer(jsonData))
+	result, err := ps.doPaymentRequest(ctx, "POST", "/v3/payments", requestBody, paymentReq.IdempotenceKey)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if result.ConfirmationURL == "" {
+		return nil, errors.New("invalid response format")
 	}
 
-	req.Header.Set("Authorization", "Basic"+ps.APIKey)
-	req.Header.Set("Content-Type", "application/json")
+	return result, nil
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
+func (ps *PaymentService) GetPayment(ctx context.Context, paymentID string) (*PaymentResult, error) {
+	return ps.doPaymentRequest(ctx, "GET", "/v3/payments/"+paymentID, nil, "")
+}
+
+func (ps *PaymentService) CapturePayment(ctx context.Context, paymentID string, amount money.Money) (*PaymentResult, error) {
+	requestBody := map[string]interface{}{
+		"amount": amount,
 	}
+	return ps.doPaymentRequest(ctx, "POST", "/v3/payments/"+paymentID+"/capture", requestBody, "capture-"+paymentID)
+}
 
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return "", errors.New(`failed to create payment`)
+func (ps *PaymentService) CancelPayment(ctx context.Context, paymentID string) (*PaymentResult, error) {
+	return ps.doPaymentRequest(ctx, "POST", "/v3/payments/"+paymentID+"/cancel", map[string]interface{}{}, "cancel-"+paymentID)
+}
+
+func (ps *PaymentService) CreateRefund(ctx context.Context, refundReq RefundRequest) (*RefundResult, error) {
+	requestBody := map[string]interface{}{
+		"payment_id":  refundReq.PaymentID,
+		"amount":      money.New(refundReq.Amount, refundReq.Currency),
+		"description": refundReq.Description,
+	}
+	if refundReq.Receipt != nil {
+		requestBody["receipt"] = refundReq.Receipt
+	}
+
+	return ps.doRefundRequest(ctx, "POST", "/v3/refunds", requestBody, refundReq.IdempotenceKey)
+}
+
+func (ps *PaymentService) GetRefund(ctx context.Context, refundID string) (*RefundResult, error) {
+	return ps.doRefundRequest(ctx, "GET", "/v3/refunds/"+refundID, nil, "")
+}
+
+func (ps *PaymentService) ParseWebhook(body []byte) (*WebhookEvent, error) {
+	return parseNotification(body)
+}
+
+func (ps *PaymentService) doRefundRequest(ctx context.Context, method, path string, body interface{}, idempotenceKey string) (*RefundResult, error) {
+	raw, err := ps.do(ctx, method, path, body, idempotenceKey)
+	if err != nil {
+		return nil, err
 	}
 
-	var response map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return "", err
+	var response struct {
+		ID        string `json:"id"`
+		PaymentID string `json:"payment_id"`
+		Status    string `json:"status"`
+	}
+	if err := json.Unmarshal(raw, &response); err != nil {
+		return nil, err
 	}
+	if response.ID == "" {
+		return nil, errors.New("invalid response format")
+	}
+
+	return &RefundResult{
+		ID:        response.ID,
+		PaymentID: response.PaymentID,
+		Status:    response.Status,
+		Raw:       raw,
+	}, nil
+}
 
-	confirmation, ok := response["confirmation"].(map[string]interface{})
-	if !ok {
-		return "", errors.New("invalid response format")
+func (ps *PaymentService) doPaymentRequest(ctx context.Context, method, path string, body interface{}, idempotenceKey string) (*PaymentResult, error) {
+	raw, err := ps.do(ctx, method, path, body, idempotenceKey)
+	if err != nil {
+		return nil, err
 	}
 
-	return confirmation["confirmation_url"].(string), nil
+	var response struct {
+		ID           string `json:"id"`
+		Status       string `json:"status"`
+		Confirmation struct {
+			ConfirmationURL string `json:"confirmation_url"`
+		} `json:"confirmation"`
+	}
+	if err := json.Unmarshal(raw, &response); err != nil {
+		return nil, err
+	}
+	if response.ID == "" || response.Status == "" {
+		return nil, errors.New("invalid response format")
+	}
 
+	return &PaymentResult{
+		ID:              response.ID,
+		Status:          response.Status,
+		ConfirmationURL: response.Confirmation.ConfirmationURL,
+		Raw:             raw,
+	}, nil
 }
 
-func (ps *PaymentService) GetPaymentStatus(paymentID string) (string, error) {
-	req, err := http.NewRequest("GET", ps.APIURL+"/v3/payments"+paymentID, nil)
+func (ps *PaymentService) do(ctx context.Context, method, path string, body interface{}, idempotenceKey string) ([]byte, error) {
+	var reqBody io.Reader
+	if body != nil {
+		jsonData, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewBuffer(jsonData)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, ps.APIURL+path, reqBody)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	req.Header.Set("Authorization", "Basic "+ps.APIKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if idempotenceKey != "" {
+		req.Header.Set("Idempotence-Key", idempotenceKey)
+	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := ps.client.Do(req)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return "", errors.New("failed to get payment status")
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
 	}
 
-	var response map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return "", err
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return nil, &ProviderError{StatusCode: resp.StatusCode, Body: string(raw)}
 	}
 
-	status, ok := response["status"].(string)
-	if !ok {
-		return "", errors.New("invalid response format")
+	return raw, nil
+}
+
+// parseNotification разбирает уведомление в формате YooKassa: {"type": "notification", "event": "...", "object": {...}}
+func parseNotification(body []byte) (*WebhookEvent, error) {
+	var payload struct {
+		Event  string `json:"event"`
+		Object struct {
+			ID     string `json:"id"`
+			Status string `json:"status"`
+		} `json:"object"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	if payload.Event == "" || payload.Object.ID == "" {
+		return nil, ErrInvalidNotification
 	}
 
-	return status, nil
+	// YooKassa не присылает ID уведомления, поэтому событие определяется типом, объектом и его статусом
+	return &WebhookEvent{
+		ID:       payload.Event + ":" + payload.Object.ID + ":" + payload.Object.Status,
+		Event:    payload.Event,
+		ObjectID: payload.Object.ID,
+		Status:   payload.Object.Status,
+		Raw:      body,
+	}, nil
 }
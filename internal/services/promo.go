@@ -0,0 +1,170 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/DrummDaddy/Booking_service/internal/models"
+	"github.com/DrummDaddy/Booking_service/internal/money"
+)
+
+var ErrPromoNotApplicable = errors.New("promo code does not apply to selected tickets")
+
+func normalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// findPromo находит промокод и проверяет, что он действует для мероприятия в данный момент.
+// Лимиты применений проверяются атомарно при погашении (PromoRepository.Redeem).
+func (bs *BookingService) findPromo(ctx context.Context, code string, event *models.Event) (*models.PromoCode, error) {
+	promo, err := bs.promoRepo.FindByCode(ctx, normalizePromoCode(code))
+	if err != nil {
+		return nil, err
+	}
+	if !promo.Active || !promo.ValidAt(time.Now()) {
+		return nil, fmt.Errorf("promo code %s is not active", promo.Code)
+	}
+	if !promo.AppliesToEvent(event.ID) {
+		return nil, fmt.Errorf("promo code %s is not valid for this event", promo.Code)
+	}
+	return promo, nil
+}
+
+// calculateDiscount считает скидку по промокоду, она не может превышать стоимость билетов
+func calculateDiscount(promo *models.PromoCode, tickets []models.BookingTicket, currency string) (money.Amount, error) {
+	var (
+		applicable money.Amount
+		units      []money.Amount
+	)
+	for _, ticket := range tickets {
+		if !promo.AppliesToTicketType(ticket.TicketTypeID) {
+			continue
+		}
+		applicable += ticket.TotalPrice
+		for i := 0; i < ticket.Quantity; i++ {
+			units = append(units, ticket.UnitPrice)
+		}
+	}
+	if len(units) == 0 {
+		return 0, ErrPromoNotApplicable
+	}
+	// Бесплатными становятся самые дешёвые билеты
+	sort.Slice(units, func(i, j int) bool { return units[i] < units[j] })
+
+	var discount money.Amount
+	switch promo.Type {
+	case models.PromoPercent:
+		discount = applicable.Percent(promo.BasisPoints, money.RoundHalfUp)
+	case models.PromoFixed:
+		amount, ok := promo.Amounts[currency]
+		if !ok {
+			return 0, fmt.Errorf("promo code %s is not valid for %s", promo.Code, currency)
+		}
+		discount = amount
+	case models.PromoBuyNGetM:
+		free := len(units) / (promo.BuyQuantity + promo.FreeQuantity) * promo.FreeQuantity
+		if free == 0 {
+			return 0, fmt.Errorf("promo code %s requires at least %d tickets", promo.Code, promo.BuyQuantity+promo.FreeQuantity)
+		}
+		discount = sumAmounts(units[:free])
+	case models.PromoFreeTicketType:
+		free := promo.FreeQuantity
+		if free == 0 {
+			free = 1
+		}
+		if free > len(units) {
+			free = len(units)
+		}
+		discount = sumAmounts(units[:free])
+	default:
+		return 0, fmt.Errorf("unknown promo type %q", promo.Type)
+	}
+
+	if discount > applicable {
+		discount = applicable
+	}
+	if discount <= 0 {
+		return 0, ErrPromoNotApplicable
+	}
+	return discount, nil
+}
+
+func sumAmounts(amounts []money.Amount) money.Amount {
+	var sum money.Amount
+	for _, amount := range amounts {
+		sum += amount
+	}
+	return sum
+}
+
+// releasePromo возвращает применение промокода, когда бронь истекла или отменена
+func (bs *BookingService) releasePromo(ctx context.Context, booking *models.Booking) error {
+	if booking.Promo == nil {
+		return nil
+	}
+	return bs.promoRepo.Release(ctx, booking.Promo.PromoID, booking.UserID)
+}
+
+func (bs *BookingService) CreatePromoCode(ctx context.Context, promo *models.PromoCode) error {
+	promo.Code = normalizePromoCode(promo.Code)
+	if err := validatePromoCode(promo); err != nil {
+		return err
+	}
+
+	promo.Redemptions = 0
+	promo.CreatedAt = time.Now()
+	return bs.promoRepo.Create(ctx, promo)
+}
+
+func (bs *BookingService) ListPromoCodes(ctx context.Context) ([]models.PromoCode, error) {
+	return bs.promoRepo.List(ctx)
+}
+
+func validatePromoCode(promo *models.PromoCode) error {
+	if promo.Code == "" {
+		return errors.New("promo code is required")
+	}
+	if promo.MaxRedemptions < 0 || promo.MaxPerUser < 0 {
+		return errors.New("usage limits must not be negative")
+	}
+	if !promo.ValidFrom.IsZero() && !promo.ValidUntil.IsZero() && !promo.ValidFrom.Before(promo.ValidUntil) {
+		return errors.New("valid_from must be before valid_until")
+	}
+
+	switch promo.Type {
+	case models.PromoPercent:
+		if promo.BasisPoints <= 0 || promo.BasisPoints > 10000 {
+			return errors.New("basis_points must be between 1 and 10000")
+		}
+	case models.PromoFixed:
+		if len(promo.Amounts) == 0 {
+			return errors.New("amounts are required")
+		}
+		for currency, amount := range promo.Amounts {
+			if !money.IsSupported(currency) {
+				return fmt.Errorf("unsupported currency %s", currency)
+			}
+			if amount <= 0 {
+				return errors.New("amounts must be positive")
+			}
+		}
+	case models.PromoBuyNGetM:
+		if promo.BuyQuantity <= 0 || promo.FreeQuantity <= 0 {
+			return errors.New("buy_quantity and free_quantity must be positive")
+		}
+	case models.PromoFreeTicketType:
+		if len(promo.TicketTypeIDs) == 0 {
+			return errors.New("ticket_type_ids are required")
+		}
+		if promo.FreeQuantity < 0 {
+			return errors.New("free_quantity must not be negative")
+		}
+	default:
+		return fmt.Errorf("unknown promo type %q", promo.Type)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DrummDaddy/Booking_service/internal/models"
+	"github.com/DrummDaddy/Booking_service/internal/money"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCalculateDiscount(t *testing.T) {
+	standard, cheap := primitive.NewObjectID(), primitive.NewObjectID()
+	// Три билета по 1000.00 и один по 500.00
+	tickets := []models.BookingTicket{
+		{TicketTypeID: standard, Quantity: 3, UnitPrice: 100000, TotalPrice: 300000},
+		{TicketTypeID: cheap, Quantity: 1, UnitPrice: 50000, TotalPrice: 50000},
+	}
+
+	tests := []struct {
+		name    string
+		promo   models.PromoCode
+		want    money.Amount
+		wantErr bool
+		// wantIs ожидаемая ошибка, если важна именно она
+		wantIs error
+	}{
+		{name: "percent", promo: models.PromoCode{Type: models.PromoPercent, BasisPoints: 1000}, want: 35000},
+		{
+			name:  "percent for one ticket type",
+			promo: models.PromoCode{Type: models.PromoPercent, BasisPoints: 1000, TicketTypeIDs: []primitive.ObjectID{standard}},
+			want:  30000,
+		},
+		{name: "fixed", promo: models.PromoCode{Type: models.PromoFixed, Amounts: map[string]money.Amount{money.RUB: 20000}}, want: 20000},
+		{
+			// Скидка не больше стоимости билетов, к которым применяется промокод
+			name:  "fixed above applicable price",
+			promo: models.PromoCode{Type: models.PromoFixed, Amounts: map[string]money.Amount{money.RUB: 100000}, TicketTypeIDs: []primitive.ObjectID{cheap}},
+			want:  50000,
+		},
+		{name: "fixed in other currency", promo: models.PromoCode{Type: models.PromoFixed, Amounts: map[string]money.Amount{money.USD: 1000}}, wantErr: true},
+		{
+			// Бесплатным становится самый дешёвый билет
+			name:  "buy two get one",
+			promo: models.PromoCode{Type: models.PromoBuyNGetM, BuyQuantity: 2, FreeQuantity: 1},
+			want:  50000,
+		},
+		{
+			name:    "buy three get one with too few tickets",
+			promo:   models.PromoCode{Type: models.PromoBuyNGetM, BuyQuantity: 3, FreeQuantity: 1, TicketTypeIDs: []primitive.ObjectID{standard}},
+			wantErr: true,
+		},
+		{
+			name:  "free ticket type defaults to one ticket",
+			promo: models.PromoCode{Type: models.PromoFreeTicketType, TicketTypeIDs: []primitive.ObjectID{standard}},
+			want:  100000,
+		},
+		{
+			name:  "free ticket type limited by tickets in booking",
+			promo: models.PromoCode{Type: models.PromoFreeTicketType, FreeQuantity: 5, TicketTypeIDs: []primitive.ObjectID{standard}},
+			want:  300000,
+		},
+		{
+			name:    "no matching tickets",
+			promo:   models.PromoCode{Type: models.PromoPercent, BasisPoints: 1000, TicketTypeIDs: []primitive.ObjectID{primitive.NewObjectID()}},
+			wantErr: true,
+			wantIs:  ErrPromoNotApplicable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := calculateDiscount(&tt.promo, tickets, money.RUB)
+			if tt.wantErr {
+				if err == nil || (tt.wantIs != nil && !errors.Is(err, tt.wantIs)) {
+					t.Fatalf("got %s, %v, want error", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("discount = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatePromoCode(t *testing.T) {
+	now := time.Now()
+	percent := func(change func(*models.PromoCode)) *models.PromoCode {
+		promo := &models.PromoCode{Code: "SALE", Type: models.PromoPercent, BasisPoints: 1000}
+		change(promo)
+		return promo
+	}
+
+	tests := []struct {
+		name    string
+		promo   *models.PromoCode
+		wantErr bool
+	}{
+		{name: "valid", promo: percent(func(p *models.PromoCode) { p.MaxRedemptions, p.MaxPerUser = 100, 1 })},
+		{name: "no code", promo: percent(func(p *models.PromoCode) { p.Code = "" }), wantErr: true},
+		{name: "negative total limit", promo: percent(func(p *models.PromoCode) { p.MaxRedemptions = -1 }), wantErr: true},
+		{name: "negative per user limit", promo: percent(func(p *models.PromoCode) { p.MaxPerUser = -1 }), wantErr: true},
+		{name: "empty validity window", promo: percent(func(p *models.PromoCode) { p.ValidFrom, p.ValidUntil = now, now }), wantErr: true},
+		{name: "percent above 100", promo: percent(func(p *models.PromoCode) { p.BasisPoints = 10001 }), wantErr: true},
+		{
+			name:    "fixed in unsupported currency",
+			promo:   &models.PromoCode{Code: "SALE", Type: models.PromoFixed, Amounts: map[string]money.Amount{"XXX": 100}},
+			wantErr: true,
+		},
+		{name: "buy n get m without free tickets", promo: &models.PromoCode{Code: "SALE", Type: models.PromoBuyNGetM, BuyQuantity: 2}, wantErr: true},
+		{name: "free ticket type without types", promo: &models.PromoCode{Code: "SALE", Type: models.PromoFreeTicketType}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePromoCode(tt.promo)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validatePromoCode() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
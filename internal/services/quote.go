@@ -0,0 +1,241 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/DrummDaddy/Booking_service/internal/models"
+	"github.com/DrummDaddy/Booking_service/internal/money"
+	"github.com/DrummDaddy/Booking_service/internal/repositories"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// bookingPlan данные для расчёта брони, которые не зависят от резерва
+type bookingPlan struct {
+	event    *models.Event
+	userID   primitive.ObjectID
+	currency string
+	feeRules []models.FeeRule
+	promo    *models.PromoCode
+
+	// baseFees и baseUnits сборы брони без сохранённых правил и число её билетов:
+	// при изменении такой брони сбор пересчитывается пропорционально билетам
+	prorateFees bool
+	baseFees    []models.AppliedFee
+	baseUnits   int
+}
+
+func (bs *BookingService) planBooking(ctx context.Context, req *models.BookingRequest) (*bookingPlan, error) {
+	if err := bs.validateBookingRequest(ctx, req); err != nil {
+		return nil, err
+	}
+
+	eventObjID, err := primitive.ObjectIDFromHex(req.EventID)
+	if err != nil {
+		return nil, errors.New("invalid event ID format")
+	}
+	userObjID, err := primitive.ObjectIDFromHex(req.UserID)
+	if err != nil {
+		return nil, errors.New("invalid user ID format")
+	}
+	event, err := bs.eventRepo.FindByID(ctx, eventObjID)
+	if err != nil {
+		return nil, errors.New("event not found")
+	}
+
+	currency := strings.ToUpper(req.Currency)
+	if currency == "" {
+		currency = event.BaseCurrency()
+	}
+	if !money.IsSupported(currency) {
+		return nil, fmt.Errorf("unsupported currency %s", currency)
+	}
+	feeRules, err := bs.feeRules(ctx, event)
+	if err != nil {
+		return nil, err
+	}
+	var promo *models.PromoCode
+	if req.PromoCode != "" {
+		if promo, err = bs.findPromo(ctx, req.PromoCode, event); err != nil {
+			return nil, err
+		}
+	}
+
+	return &bookingPlan{
+		event:    event,
+		userID:   userObjID,
+		currency: currency,
+		feeRules: feeRules,
+		promo:    promo,
+	}, nil
+}
+
+type bookingTotals struct {
+	subtotal   money.Amount
+	discount   money.Amount
+	serviceFee money.Amount
+	total      money.Amount
+	fees       []models.AppliedFee
+}
+
+func (bs *BookingService) calculateTotals(plan *bookingPlan, tickets []models.BookingTicket) (*bookingTotals, error) {
+	subtotal, discount, err := bs.calculateSubtotal(tickets, plan.promo, plan.currency)
+	if err != nil {
+		return nil, err
+	}
+	var fees []models.AppliedFee
+	var serviceFee money.Amount
+	if plan.prorateFees {
+		fees, serviceFee = prorateFees(plan.baseFees, plan.baseUnits, tickets)
+	} else if fees, serviceFee, err = calculateFees(plan.feeRules, tickets, plan.currency); err != nil {
+		return nil, err
+	}
+
+	return &bookingTotals{
+		subtotal:   subtotal,
+		discount:   discount,
+		serviceFee: serviceFee,
+		total:      subtotal - discount + serviceFee,
+		fees:       fees,
+	}, nil
+}
+
+// QuoteBooking считает корзину так же, как CreatingBooking, но ничего не резервирует:
+// счётчики билетов, места и промокоды только читаются.
+func (bs *BookingService) QuoteBooking(ctx context.Context, req *models.BookingRequest) (*models.Quote, error) {
+	plan, err := bs.planBooking(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := bs.checkPromoLimits(ctx, plan.promo, plan.userID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	quote := &models.Quote{
+		EventID:   plan.event.ID.Hex(),
+		Currency:  plan.currency,
+		Available: true,
+		QuotedAt:  now,
+	}
+
+	for _, selection := range req.Tickets {
+		ticketType, err := findTicketType(plan.event, selection.TicketID)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := ticketType.PriceIn(plan.currency, plan.event.BaseCurrency()); !ok {
+			return nil, fmt.Errorf("ticket type %s is not sold in %s", ticketType.Name, plan.currency)
+		}
+
+		availability := models.TicketAvailability{
+			TicketTypeID: ticketType.ID,
+			Name:         ticketType.Name,
+			Requested:    selection.Quantity,
+			Available:    ticketType.Available(),
+		}
+		if availability.Available < selection.Quantity {
+			quote.Available = false
+		}
+
+		unavailable, err := bs.unavailableSeats(ctx, plan.event.ID, ticketType, selection)
+		if err != nil {
+			return nil, err
+		}
+		if len(unavailable) > 0 {
+			availability.UnavailableSeats = unavailable
+			quote.Available = false
+		}
+		quote.Availability = append(quote.Availability, availability)
+
+		lines, err := priceLines(ticketType, selection.Quantity, plan.currency, plan.event.BaseCurrency(), now)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range lines {
+			quote.Tickets = append(quote.Tickets, models.BookingTicket{
+				TicketTypeID:   ticketType.ID,
+				TicketTypeName: ticketType.Name,
+				Quantity:       line.quantity,
+				UnitPrice:      line.price,
+				TotalPrice:     line.price.Mul(line.quantity),
+				PriceTier:      line.tier,
+			})
+		}
+	}
+
+	totals, err := bs.calculateTotals(plan, quote.Tickets)
+	if err != nil {
+		return nil, err
+	}
+	quote.Subtotal = totals.subtotal
+	quote.Discount = totals.discount
+	quote.ServiceFee = totals.serviceFee
+	quote.Fees = totals.fees
+	quote.TotalAmount = totals.total
+	if promo := plan.promo; promo != nil {
+		quote.Promo = &models.BookingPromo{PromoID: promo.ID, Code: promo.Code, Type: promo.Type}
+	}
+
+	return quote, nil
+}
+
+func findTicketType(event *models.Event, ticketID string) (*models.TicketType, error) {
+	ticketTypeID, err := primitive.ObjectIDFromHex(ticketID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ticlet ID format: %s", ticketID)
+	}
+	for i := range event.TicketTypes {
+		if event.TicketTypes[i].ID == ticketTypeID {
+			return &event.TicketTypes[i], nil
+		}
+	}
+	return nil, fmt.Errorf("ticket type %s not found", ticketID)
+}
+
+func (bs *BookingService) unavailableSeats(ctx context.Context, eventID primitive.ObjectID, ticketType *models.TicketType, selection models.TicketSelection) ([]string, error) {
+	seatIDs, err := selectedSeatIDs(ticketType, selection)
+	if err != nil || len(seatIDs) == 0 {
+		return nil, err
+	}
+
+	free, err := bs.seatRepo.FindFree(ctx, eventID, ticketType.ID, seatIDs)
+	if err != nil {
+		return nil, err
+	}
+	isFree := make(map[primitive.ObjectID]bool, len(free))
+	for _, seat := range free {
+		isFree[seat.ID] = true
+	}
+
+	var unavailable []string
+	for _, id := range seatIDs {
+		if !isFree[id] {
+			unavailable = append(unavailable, id.Hex())
+		}
+	}
+	return unavailable, nil
+}
+
+// checkPromoLimits проверяет лимиты промокода без погашения
+func (bs *BookingService) checkPromoLimits(ctx context.Context, promo *models.PromoCode, userID primitive.ObjectID) error {
+	if promo == nil {
+		return nil
+	}
+	if promo.MaxRedemptions > 0 && promo.Redemptions >= promo.MaxRedemptions {
+		return repositories.ErrPromoExhausted
+	}
+	if promo.MaxPerUser > 0 {
+		used, err := bs.promoRepo.UsageCount(ctx, promo.ID, userID)
+		if err != nil {
+			return err
+		}
+		if used >= promo.MaxPerUser {
+			return repositories.ErrPromoUserExhausted
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+
+	"github.com/DrummDaddy/Booking_service/internal/models"
+	"github.com/DrummDaddy/Booking_service/internal/money"
+)
+
+// Ограничение провайдера на длину названия позиции чека
+const receiptDescriptionLimit = 128
+
+// receiptRequired чек по 54-ФЗ формируется для расчётов в рублях
+func receiptRequired(currency string) bool {
+	return currency == money.RUB
+}
+
+// normalizeReceiptCustomer проверяет контакты покупателя, на которые придёт чек.
+// Телефон приводится к цифрам в международном формате: +7 (900) 000-00-00 -> 79000000000.
+func normalizeReceiptCustomer(customer models.ReceiptCustomer) (models.ReceiptCustomer, error) {
+	customer.Email = strings.TrimSpace(customer.Email)
+	if customer.Email != "" && !strings.Contains(customer.Email, "@") {
+		return customer, errors.New("invalid customer email")
+	}
+
+	if customer.Phone != "" {
+		digits := strings.Map(func(r rune) rune {
+			if unicode.IsDigit(r) {
+				return r
+			}
+			return -1
+		}, customer.Phone)
+		if len(digits) < 11 || len(digits) > 15 {
+			return customer, errors.New("invalid customer phone")
+		}
+		customer.Phone = digits
+	}
+
+	if customer.Email == "" && customer.Phone == "" {
+		return customer, errors.New("customer email or phone is required for the receipt")
+	}
+	return customer, nil
+}
+
+// buildReceipt формирует чек из строк билетов брони и сервисного сбора.
+// Скидка распределяется по строкам пропорционально их стоимости, поэтому сумма чека равна TotalAmount.
+func buildReceipt(booking *models.Booking, event *models.Event, customer models.ReceiptCustomer) *models.Receipt {
+	settings := event.FiscalSettings()
+	receipt := &models.Receipt{
+		Customer:      customer,
+		TaxSystemCode: settings.TaxSystemCode,
+	}
+
+	lineTotals := make([]money.Amount, len(booking.Tickets))
+	for i, ticket := range booking.Tickets {
+		lineTotals[i] = ticket.TotalPrice
+	}
+	lineTotals = allocateDiscount(lineTotals, booking.Discount)
+
+	for i, ticket := range booking.Tickets {
+		description := event.Name + ": " + ticket.TicketTypeName
+		receipt.Items = append(receipt.Items, receiptItems(description, lineTotals[i], ticket.Quantity, booking.Currency, settings.VatCode, settings)...)
+	}
+	for _, fee := range booking.Fees {
+		if fee.Absorbed || fee.Amount <= 0 {
+			continue
+		}
+		receipt.Items = append(receipt.Items, receiptItems("Сервисный сбор", fee.Amount, 1, booking.Currency, settings.FeeVatCode, settings)...)
+	}
+
+	return receipt
+}
+
+// allocateDiscount уменьшает суммы строк на discount пропорционально их величине.
+// Копейки от округления вниз раздаются по одной первым строкам, у которых остался запас.
+func allocateDiscount(totals []money.Amount, discount money.Amount) []money.Amount {
+	var subtotal money.Amount
+	for _, total := range totals {
+		subtotal += total
+	}
+	if discount <= 0 || subtotal <= 0 {
+		return totals
+	}
+	if discount > subtotal {
+		discount = subtotal
+	}
+
+	result := make([]money.Amount, len(totals))
+	remaining := discount
+	for i, total := range totals {
+		share := total.MulRat(int64(discount), int64(subtotal), money.RoundDown)
+		result[i] = total - share
+		remaining -= share
+	}
+	for i := 0; remaining > 0; i = (i + 1) % len(result) {
+		if result[i] > 0 {
+			result[i]--
+			remaining--
+		}
+	}
+	return result
+}
+
+// receiptItems раскладывает сумму строки на позиции с ценой за единицу. Если сумма не делится
+// на количество без остатка, часть единиц получает цену на копейку больше.
+func receiptItems(description string, total money.Amount, quantity int, currency string, vatCode int, settings models.FiscalSettings) []models.ReceiptItem {
+	if quantity <= 0 {
+		return nil
+	}
+	if runes := []rune(description); len(runes) > receiptDescriptionLimit {
+		description = string(runes[:receiptDescriptionLimit])
+	}
+
+	unit := total / money.Amount(quantity)
+	extra := int(total % money.Amount(quantity))
+
+	item := func(price money.Amount, n int) models.ReceiptItem {
+		return models.ReceiptItem{
+			Description:    description,
+			Quantity:       n,
+			Amount:         money.New(price, currency),
+			VatCode:        vatCode,
+			PaymentSubject: settings.PaymentSubject,
+			PaymentMode:    settings.PaymentMode,
+		}
+	}
+
+	var items []models.ReceiptItem
+	if quantity-extra > 0 {
+		items = append(items, item(unit, quantity-extra))
+	}
+	if extra > 0 {
+		items = append(items, item(unit+1, extra))
+	}
+	return items
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/DrummDaddy/Booking_service/internal/models"
+	"github.com/DrummDaddy/Booking_service/internal/money"
+)
+
+func TestAllocateDiscount(t *testing.T) {
+	tests := []struct {
+		name     string
+		totals   []money.Amount
+		discount money.Amount
+		want     []money.Amount
+	}{
+		{name: "no discount", totals: []money.Amount{10000, 20000}, want: []money.Amount{10000, 20000}},
+		{name: "proportional", totals: []money.Amount{10000, 30000}, discount: 4000, want: []money.Amount{9000, 27000}},
+		// 100 на три строки: по 33 и копейка округления с первой строки
+		{name: "rounding remainder", totals: []money.Amount{100, 100, 100}, discount: 100, want: []money.Amount{66, 67, 67}},
+		{name: "free line keeps zero", totals: []money.Amount{0, 100}, discount: 10, want: []money.Amount{0, 90}},
+		{name: "discount above subtotal", totals: []money.Amount{100, 50}, discount: 1000, want: []money.Amount{0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := allocateDiscount(tt.totals, tt.discount)
+			if len(got) != len(tt.want) {
+				t.Fatalf("allocateDiscount = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("allocateDiscount = %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestReceiptItems(t *testing.T) {
+	settings := models.FiscalSettings{PaymentSubject: "service", PaymentMode: "full_payment"}
+	type item struct {
+		price    money.Amount
+		quantity int
+	}
+	tests := []struct {
+		name     string
+		total    money.Amount
+		quantity int
+		want     []item
+	}{
+		{name: "even split", total: 30000, quantity: 3, want: []item{{10000, 3}}},
+		// Сумма чека должна совпасть с суммой строки, поэтому одна позиция дороже на копейку
+		{name: "uneven split", total: 10000, quantity: 3, want: []item{{3333, 2}, {3334, 1}}},
+		{name: "single unit", total: 5000, quantity: 1, want: []item{{5000, 1}}},
+		{name: "no units", total: 5000, quantity: 0, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items := receiptItems("Концерт: партер", tt.total, tt.quantity, money.RUB, models.VatCodeNone, settings)
+			var got []item
+			var sum money.Amount
+			for _, it := range items {
+				got = append(got, item{it.Amount.Amount, it.Quantity})
+				sum += it.Amount.Amount.Mul(it.Quantity)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("items = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("items = %v, want %v", got, tt.want)
+					break
+				}
+			}
+			if tt.quantity > 0 && sum != tt.total {
+				t.Errorf("items sum = %s, want %s", sum, tt.total)
+			}
+		})
+	}
+
+	// Название обрезается по символам, а не по байтам
+	items := receiptItems(strings.Repeat("я", receiptDescriptionLimit+10), 100, 1, money.RUB, models.VatCodeNone, settings)
+	if n := len([]rune(items[0].Description)); n != receiptDescriptionLimit {
+		t.Errorf("description has %d characters, want %d", n, receiptDescriptionLimit)
+	}
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+type ReconciliationStats struct {
+	Runs    int64     `json:"runs"`
+	Checked int64     `json:"checked"`
+	Changed int64     `json:"changed"`
+	Failed  int64     `json:"failed"`
+	LastRun time.Time `json:"last_run"`
+}
+
+// ReconciliationWorker периодически сверяет с провайдером платежи без итогового статуса старше minAge
+type ReconciliationWorker struct {
+	service  *BookingService
+	interval time.Duration
+	minAge   time.Duration
+
+	mu    sync.Mutex
+	stats ReconciliationStats
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewReconciliationWorker(service *BookingService, interval, minAge time.Duration) *ReconciliationWorker {
+	return &ReconciliationWorker{
+		service:  service,
+		interval: interval,
+		minAge:   minAge,
+	}
+}
+
+func (w *ReconciliationWorker) Start(ctx context.Context) {
+	ctx, w.cancel = context.WithCancel(ctx)
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.run(ctx)
+	}()
+}
+
+func (w *ReconciliationWorker) Stop() {
+	if w.cancel != nil {
+		w.cancel()
+	}
+	w.wg.Wait()
+}
+
+func (w *ReconciliationWorker) Stats() ReconciliationStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.stats
+}
+
+func (w *ReconciliationWorker) run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		report, err := w.service.ReconcilePayments(ctx, time.Time{}, time.Now().Add(-w.minAge))
+		if err != nil && ctx.Err() == nil {
+			log.Printf("reconciliation worker: %v", err)
+		}
+		if report == nil {
+			continue
+		}
+
+		w.mu.Lock()
+		w.stats.Runs++
+		w.stats.Checked += int64(report.Checked)
+		w.stats.Changed += int64(report.Changed)
+		w.stats.Failed += int64(report.Failed)
+		w.stats.LastRun = time.Now()
+		w.mu.Unlock()
+	}
+}
@@ -1,6 +1,10 @@
 package services
 
 import (
+	"context"
+	"errors"
+	"time"
+
 	"github.com/go-redis/redis/v8"
 )
 
@@ -19,3 +23,67 @@ func NewRedisCache(addr string, password string, db int) *RedisCache {
 		client: client,
 	}
 }
+
+func (rc *RedisCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := rc.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (rc *RedisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return rc.client.Set(ctx, key, value, ttl).Err()
+}
+
+func (rc *RedisCache) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	return rc.client.SetNX(ctx, key, value, ttl).Result()
+}
+
+func (rc *RedisCache) Delete(ctx context.Context, key string) error {
+	return rc.client.Del(ctx, key).Err()
+}
+
+// compareAndSetScript перезаписывает ключ, только если он всё ещё хранит ожидаемое значение
+var compareAndSetScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+end
+return false
+`)
+
+// compareAndDeleteScript удаляет ключ, только если он всё ещё хранит ожидаемое значение
+var compareAndDeleteScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// CompareAndSet атомарно заменяет old на value. false, если ключ истёк или его уже перезаписали.
+func (rc *RedisCache) CompareAndSet(ctx context.Context, key string, old, value []byte, ttl time.Duration) (bool, error) {
+	err := compareAndSetScript.Run(ctx, rc.client, []string{key}, old, value, ttl.Milliseconds()).Err()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CompareAndDelete атомарно удаляет ключ, если он хранит old
+func (rc *RedisCache) CompareAndDelete(ctx context.Context, key string, old []byte) (bool, error) {
+	deleted, err := compareAndDeleteScript.Run(ctx, rc.client, []string{key}, old).Int()
+	if err != nil {
+		return false, err
+	}
+	return deleted > 0, nil
+}
+
+func (rc *RedisCache) Close() error {
+	return rc.client.Close()
+}
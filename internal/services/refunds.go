@@ -0,0 +1,618 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/DrummDaddy/Booking_service/internal/models"
+	"github.com/DrummDaddy/Booking_service/internal/money"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrNothingToRefund = errors.New("nothing to refund")
+	// ErrRefundRejected провайдер не принял ни одной части возврата
+	ErrRefundRejected = errors.New("refund was rejected by payment provider")
+)
+
+// PartialRefundError часть возврата провайдер принял, часть отклонил. Refunds все части со статусами и ошибками:
+// принятые уже списаны с брони, отклонённые можно оформить заново.
+type PartialRefundError struct {
+	Refunds []models.Refund
+}
+
+func (e *PartialRefundError) Error() string {
+	rejected := 0
+	for _, refund := range e.Refunds {
+		if refund.Rejected() {
+			rejected++
+		}
+	}
+	return fmt.Sprintf("%d of %d refund parts were rejected by payment provider", rejected, len(e.Refunds))
+}
+
+// RefundBooking оформляет полный или частичный возврат по оплаченной брони. Если бронь оплачена
+// несколькими платежами (доплата за обмен), возвращается по записи на каждый задействованный платёж.
+func (bs *BookingService) RefundBooking(ctx context.Context, bookingID string, req models.RefundRequest, actor string) ([]models.Refund, error) {
+	bookingObjID, err := primitive.ObjectIDFromHex(bookingID)
+	if err != nil {
+		return nil, ErrBookingNotFound
+	}
+	booking, err := bs.bookingRepo.FindByID(ctx, bookingObjID)
+	if err != nil {
+		return nil, ErrBookingNotFound
+	}
+
+	return bs.refund(ctx, booking, req, actor)
+}
+
+// ListRefunds возвращает возвраты брони. Пустой userID означает запрос администратора без проверки владельца.
+func (bs *BookingService) ListRefunds(ctx context.Context, bookingID, userID string) ([]models.Refund, error) {
+	var booking *models.Booking
+	var err error
+	if userID != "" {
+		booking, err = bs.Getbooking(ctx, bookingID, userID)
+	} else {
+		bookingObjID, _ := primitive.ObjectIDFromHex(bookingID)
+		booking, err = bs.bookingRepo.FindByID(ctx, bookingObjID)
+	}
+	if err != nil {
+		return nil, ErrBookingNotFound
+	}
+
+	return bs.refundRepo.ListByBooking(ctx, booking.ID)
+}
+
+// refund резервирует сумму и билеты возврата в брони, затем отправляет запрос провайдеру.
+// Остатки билетов меняются только когда провайдер подтвердит возврат (applyRefundStatus).
+func (bs *BookingService) refund(ctx context.Context, booking *models.Booking, req models.RefundRequest, actor string) ([]models.Refund, error) {
+	if booking.Status != models.BookingStatusConfirmed {
+		return nil, errors.New("only confirmed bookings can be refunded")
+	}
+	if booking.PendingModification() != nil {
+		return nil, errors.New("booking has a ticket exchange waiting for payment")
+	}
+	if booking.PaymentID == "" {
+		return nil, errors.New("booking has no payment to refund")
+	}
+	payment, err := bs.paymentRepo.FindByProviderID(ctx, booking.PaymentID)
+	if err != nil {
+		return nil, err
+	}
+	if payment.Status != models.PaymentStatusSucceeded {
+		return nil, errors.New("payment is not captured")
+	}
+	event, err := bs.eventRepo.FindByID(ctx, booking.EventID)
+	if err != nil {
+		return nil, errors.New("event not found")
+	}
+
+	prevRefunded, prevQuantities := booking.RefundedAmount, refundedQuantities(booking)
+	// Сумма брони после обменов равна сумме всех её платежей за вычетом возвращённой разницы
+	refund, err := planRefund(booking, booking.TotalAmount, req)
+	if err != nil {
+		return nil, err
+	}
+	refund.BookingID = booking.ID
+	refund.Currency = booking.Currency
+	refund.Reason = req.Reason
+	refund.Actor = actor
+	parts, err := bs.splitRefund(ctx, booking, event, refund)
+	if err != nil {
+		return nil, err
+	}
+
+	err = bs.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := bs.bookingRepo.UpdateRefundState(ctx, booking, prevRefunded, prevQuantities); err != nil {
+			return err
+		}
+		for _, part := range parts {
+			if err := bs.refundRepo.Create(ctx, part); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return bs.sendRefunds(ctx, parts)
+}
+
+// splitRefund раскладывает возврат по успешным платежам брони: сначала основной платёж, затем доплаты.
+// Возвращаемые билеты и сбор учитываются в первой записи, остальные возвращают только сумму.
+func (bs *BookingService) splitRefund(ctx context.Context, booking *models.Booking, event *models.Event, refund *models.Refund) ([]*models.Refund, error) {
+	payments, err := bs.paymentRepo.FindByBookingID(ctx, booking.ID)
+	if err != nil {
+		return nil, err
+	}
+	refunds, err := bs.refundRepo.ListByBooking(ctx, booking.ID)
+	if err != nil {
+		return nil, err
+	}
+	allocations, err := allocateRefund(payments, refunds, booking.PaymentID, refund.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := make([]*models.Refund, 0, len(allocations))
+	for _, allocation := range allocations {
+		part := *refund
+		part.ID = primitive.NewObjectID()
+		part.PaymentID = allocation.payment.ProviderPaymentID
+		part.Amount = allocation.amount
+		part.Status = models.RefundStatusPending
+		part.CreatedAt = time.Now()
+		part.UpdatedAt = part.CreatedAt
+		if len(parts) > 0 {
+			part.Lines = nil
+			part.FeeAmount = 0
+		}
+		if allocation.payment.Receipt != nil {
+			part.Receipt = buildRefundReceipt(event, &part, allocation.payment.Receipt.Customer, allocation.payment.Receipt.TaxSystemCode)
+		}
+		parts = append(parts, &part)
+	}
+	return parts, nil
+}
+
+type refundAllocation struct {
+	payment *models.Payment
+	amount  money.Amount
+}
+
+// allocateRefund делит сумму возврата между успешными платежами брони с учётом уже оформленных по ним возвратов:
+// сначала основной платёж mainPaymentID, затем доплаты в порядке создания
+func allocateRefund(payments []models.Payment, refunds []models.Refund, mainPaymentID string, amount money.Amount) ([]refundAllocation, error) {
+	refunded := make(map[string]money.Amount)
+	for _, r := range refunds {
+		if r.Status == models.RefundStatusPending || r.Status == models.RefundStatusSucceeded {
+			refunded[r.PaymentID] += r.Amount
+		}
+	}
+	payments = append([]models.Payment(nil), payments...)
+	sort.SliceStable(payments, func(i, j int) bool {
+		if main := payments[i].ProviderPaymentID == mainPaymentID; main != (payments[j].ProviderPaymentID == mainPaymentID) {
+			return main
+		}
+		return payments[i].CreatedAt.Before(payments[j].CreatedAt)
+	})
+
+	var allocations []refundAllocation
+	left := amount
+	for i := range payments {
+		payment := &payments[i]
+		if payment.Status != models.PaymentStatusSucceeded {
+			continue
+		}
+		available := payment.Amount - refunded[payment.ProviderPaymentID]
+		// Возврат бесплатных билетов оформляется одной записью на основной платёж
+		if available <= 0 && !(left == 0 && len(allocations) == 0) {
+			continue
+		}
+
+		allocation := refundAllocation{payment: payment, amount: min(left, available)}
+		allocations = append(allocations, allocation)
+		left -= allocation.amount
+		if left <= 0 {
+			break
+		}
+	}
+	if len(allocations) == 0 || left > 0 {
+		return nil, errors.New("refund exceeds captured payments")
+	}
+	return allocations, nil
+}
+
+// sendRefunds отправляет созданные возвраты провайдеру и возвращает все части с их статусами.
+// Если провайдер отклонил все части, возвращается ErrRefundRejected с причинами, если только часть, PartialRefundError.
+// Части без ответа провайдера остаются в pending и доводятся сверкой, это не ошибка.
+func (bs *BookingService) sendRefunds(ctx context.Context, refunds []*models.Refund) ([]models.Refund, error) {
+	sent := make([]models.Refund, 0, len(refunds))
+	var errs []error
+	rejected := 0
+	for _, refund := range refunds {
+		if err := bs.sendRefund(ctx, refund); err != nil {
+			log.Printf("refund %s: %v", refund.ID.Hex(), err)
+			errs = append(errs, err)
+		}
+		if refund.Rejected() {
+			rejected++
+		}
+		sent = append(sent, *refund)
+	}
+
+	switch {
+	case rejected == 0:
+		return sent, nil
+	case rejected == len(sent):
+		return sent, fmt.Errorf("%w: %w", ErrRefundRejected, errors.Join(errs...))
+	default:
+		return sent, &PartialRefundError{Refunds: sent}
+	}
+}
+
+func (bs *BookingService) sendRefund(ctx context.Context, refund *models.Refund) error {
+	// Возврат бесплатных билетов не требует обращения к провайдеру
+	if refund.Amount == 0 {
+		return bs.applyRefundStatus(ctx, refund, models.RefundStatusSucceeded)
+	}
+
+	result, err := bs.paymentProvider.CreateRefund(ctx, RefundRequest{
+		PaymentID:      refund.PaymentID,
+		Amount:         refund.Amount,
+		Currency:       refund.Currency,
+		Description:    "Возврат бронирования",
+		IdempotenceKey: refund.ID.Hex(),
+		Receipt:        refund.Receipt,
+	})
+	if err != nil {
+		// Без ответа провайдера возврат мог быть создан: он остаётся в pending,
+		// и сверка повторит запрос с тем же ключом идемпотентности
+		if !isProviderRejection(err) {
+			log.Printf("refund %s left pending: %v", refund.ID.Hex(), err)
+			return nil
+		}
+		if failErr := bs.failRefund(ctx, refund, err); failErr != nil {
+			log.Printf("rollback refund %s: %v", refund.ID.Hex(), failErr)
+		}
+		return err
+	}
+
+	refund.ProviderRefundID = result.ID
+	if err := bs.refundRepo.SetProviderResult(ctx, refund.ID, result.ID); err != nil {
+		return err
+	}
+
+	status := models.RefundStatus(result.Status)
+	if status != models.RefundStatusPending {
+		return bs.applyRefundStatus(ctx, refund, status)
+	}
+	return nil
+}
+
+// planRefund считает сумму возврата и отмечает возвращаемые билеты в booking.
+// Цена билета берётся с учётом скидки, а копейки округления накопительно: после возврата всех
+// билетов строки возвращается ровно её стоимость. Сервисный сбор возвращается пропорционально числу билетов.
+func planRefund(booking *models.Booking, captured money.Amount, req models.RefundRequest) (*models.Refund, error) {
+	if len(req.Lines) > 0 && req.Amount != 0 {
+		return nil, errors.New("refund either ticket lines or an amount")
+	}
+	if req.Amount < 0 {
+		return nil, errors.New("refund amount must be positive")
+	}
+
+	remaining := captured - booking.RefundedAmount
+	refund := &models.Refund{}
+
+	if req.Amount > 0 {
+		limit := remaining
+		if req.ExcludeFee {
+			paidForTickets := (captured - booking.ServiceFree) - (booking.RefundedAmount - booking.RefundedFee)
+			if paidForTickets < limit {
+				limit = paidForTickets
+			}
+		}
+		if req.Amount > limit {
+			return nil, fmt.Errorf("refund amount exceeds refundable %s", limit)
+		}
+		refund.Amount = req.Amount
+		booking.RefundedAmount += refund.Amount
+		return refund, nil
+	}
+
+	lines := req.Lines
+	if len(lines) == 0 {
+		for i, ticket := range booking.Tickets {
+			if ticket.ActiveQuantity() > 0 {
+				lines = append(lines, models.TicketLineRequest{TicketIndex: i, Quantity: ticket.ActiveQuantity()})
+			}
+		}
+	}
+	if len(lines) == 0 {
+		return nil, ErrNothingToRefund
+	}
+
+	lineTotals := make([]money.Amount, len(booking.Tickets))
+	var totalUnits, unitsBefore int
+	for i, ticket := range booking.Tickets {
+		lineTotals[i] = ticket.TotalPrice
+		totalUnits += ticket.Quantity
+		unitsBefore += ticket.RefundedQuantity
+	}
+	netTotals := allocateDiscount(lineTotals, booking.Discount)
+
+	unitsAfter := unitsBefore
+	seen := make(map[int]bool, len(lines))
+	for _, line := range lines {
+		ticket, seats, err := selectTicketLine(booking.Tickets, line, seen)
+		if err != nil {
+			return nil, err
+		}
+
+		before, after := ticket.RefundedQuantity, ticket.RefundedQuantity+line.Quantity
+		net := netTotals[line.TicketIndex]
+		amount := net.MulRat(int64(after), int64(ticket.Quantity), money.RoundDown) -
+			net.MulRat(int64(before), int64(ticket.Quantity), money.RoundDown)
+
+		ticket.RefundedQuantity = after
+		ticket.RefundedSeats = append(ticket.RefundedSeats, seats...)
+		unitsAfter += line.Quantity
+
+		refund.Lines = append(refund.Lines, models.RefundLine{
+			TicketIndex:  line.TicketIndex,
+			TicketTypeID: ticket.TicketTypeID,
+			Quantity:     line.Quantity,
+			Seats:        seats,
+			Amount:       amount,
+		})
+		refund.Amount += amount
+	}
+
+	if !req.ExcludeFee && totalUnits > 0 {
+		target := booking.ServiceFree.MulRat(int64(unitsAfter), int64(totalUnits), money.RoundDown)
+		if target > booking.RefundedFee {
+			refund.FeeAmount = target - booking.RefundedFee
+			refund.Amount += refund.FeeAmount
+		}
+	}
+
+	// После возврата произвольной суммы остаток может быть меньше стоимости билетов
+	if refund.Amount > remaining {
+		refund.Amount = remaining
+		if refund.FeeAmount > refund.Amount {
+			refund.FeeAmount = refund.Amount
+		}
+	}
+	booking.RefundedAmount += refund.Amount
+	booking.RefundedFee += refund.FeeAmount
+	return refund, nil
+}
+
+// selectTicketLine проверяет строку запроса и возвращает строку брони и её выбранные места
+func selectTicketLine(tickets []models.BookingTicket, line models.TicketLineRequest, seen map[int]bool) (*models.BookingTicket, []string, error) {
+	if line.TicketIndex < 0 || line.TicketIndex >= len(tickets) || seen[line.TicketIndex] {
+		return nil, nil, fmt.Errorf("invalid ticket_index %d", line.TicketIndex)
+	}
+	seen[line.TicketIndex] = true
+
+	ticket := &tickets[line.TicketIndex]
+	if line.Quantity <= 0 || line.Quantity > ticket.ActiveQuantity() {
+		return nil, nil, fmt.Errorf("ticket line %d has %d active tickets", line.TicketIndex, ticket.ActiveQuantity())
+	}
+	seats, err := lineSeats(ticket, line)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ticket, seats, nil
+}
+
+// lineSeats проверяет выбранные места строки или берёт последние невозвращённые места строки
+func lineSeats(ticket *models.BookingTicket, line models.TicketLineRequest) ([]string, error) {
+	if len(ticket.Seats) == 0 {
+		if len(line.Seats) > 0 {
+			return nil, fmt.Errorf("ticket line %d has no assigned seating", line.TicketIndex)
+		}
+		return nil, nil
+	}
+
+	active := ticket.ActiveSeats()
+	if len(line.Seats) == 0 {
+		seats := make([]string, 0, line.Quantity)
+		for _, seat := range active[len(active)-line.Quantity:] {
+			seats = append(seats, seat.SeatID)
+		}
+		return seats, nil
+	}
+
+	if len(line.Seats) != line.Quantity {
+		return nil, errors.New("number of seats must match ticket quantity")
+	}
+	isActive := make(map[string]bool, len(active))
+	for _, seat := range active {
+		isActive[seat.SeatID] = true
+	}
+	for _, id := range line.Seats {
+		if !isActive[id] {
+			return nil, fmt.Errorf("seat %s is not in ticket line %d", id, line.TicketIndex)
+		}
+		isActive[id] = false
+	}
+	return line.Seats, nil
+}
+
+// applyRefundStatus применяет итоговый статус возврата. Проведённый возврат возвращает билеты и места
+// в продажу, а когда возвращены все билеты, бронь отменяется. Отменённый возврат снимается с брони.
+func (bs *BookingService) applyRefundStatus(ctx context.Context, refund *models.Refund, status models.RefundStatus) error {
+	switch status {
+	case models.RefundStatusSucceeded:
+		return bs.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+			ok, err := bs.refundRepo.UpdateStatus(ctx, refund.ID, models.RefundStatusPending, status, "")
+			if err != nil || !ok {
+				return err
+			}
+			refund.Status = status
+			if !refund.ModificationID.IsZero() {
+				return nil
+			}
+
+			booking, err := bs.bookingRepo.FindByID(ctx, refund.BookingID)
+			if err != nil {
+				return err
+			}
+			return bs.returnRefundedTickets(ctx, booking, refund)
+		})
+	case models.RefundStatusCanceled:
+		return bs.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+			ok, err := bs.refundRepo.UpdateStatus(ctx, refund.ID, models.RefundStatusPending, status, "")
+			if err != nil || !ok {
+				return err
+			}
+			refund.Status = status
+			return bs.revertRefund(ctx, refund)
+		})
+	}
+	return nil
+}
+
+// failRefund снимает с брони возврат, который провайдер не принял
+func (bs *BookingService) failRefund(ctx context.Context, refund *models.Refund, cause error) error {
+	return bs.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		ok, err := bs.refundRepo.UpdateStatus(ctx, refund.ID, models.RefundStatusPending, models.RefundStatusFailed, cause.Error())
+		if err != nil || !ok {
+			return err
+		}
+		refund.Status = models.RefundStatusFailed
+		refund.Error = cause.Error()
+		return bs.revertRefund(ctx, refund)
+	})
+}
+
+func (bs *BookingService) revertRefund(ctx context.Context, refund *models.Refund) error {
+	// Разница за обмен не резервировалась в брони, её возврат нужно повторить вручную
+	if !refund.ModificationID.IsZero() {
+		log.Printf("refund %s of ticket exchange %s was not completed", refund.ID.Hex(), refund.ModificationID.Hex())
+		return nil
+	}
+	booking, err := bs.bookingRepo.FindByID(ctx, refund.BookingID)
+	if err != nil {
+		return err
+	}
+	prevRefunded, prevQuantities := booking.RefundedAmount, refundedQuantities(booking)
+
+	for _, line := range refund.Lines {
+		ticket := &booking.Tickets[line.TicketIndex]
+		ticket.RefundedQuantity -= line.Quantity
+		ticket.RefundedSeats = removeSeatIDs(ticket.RefundedSeats, line.Seats)
+	}
+	booking.RefundedAmount -= refund.Amount
+	booking.RefundedFee -= refund.FeeAmount
+
+	return bs.bookingRepo.UpdateRefundState(ctx, booking, prevRefunded, prevQuantities)
+}
+
+// refundedQuantities сколько билетов каждой строки брони уже возвращено, для условного обновления UpdateRefundState
+func refundedQuantities(booking *models.Booking) []int {
+	quantities := make([]int, len(booking.Tickets))
+	for i, ticket := range booking.Tickets {
+		quantities[i] = ticket.RefundedQuantity
+	}
+	return quantities
+}
+
+func (bs *BookingService) returnRefundedTickets(ctx context.Context, booking *models.Booking, refund *models.Refund) error {
+	for _, line := range refund.Lines {
+		if err := bs.ticketRepo.ReturnSoldTickets(ctx, booking.EventID, line.TicketTypeID, line.Quantity); err != nil {
+			return err
+		}
+		if len(line.Seats) > 0 {
+			seatIDs, err := seatObjectIDs(line.Seats)
+			if err != nil {
+				return err
+			}
+			if err := bs.seatRepo.ReleaseSeatIDs(ctx, booking.ID, seatIDs); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, ticket := range booking.Tickets {
+		if ticket.ActiveQuantity() > 0 {
+			return nil
+		}
+	}
+	if booking.Status != models.BookingStatusConfirmed {
+		return nil
+	}
+	// Все билеты возвращены: бронь отменяется, остатки уже вернулись построчно
+	if err := bs.transition(ctx, booking, models.BookingStatusCancelled, refund.Reason, refund.Actor); err != nil {
+		return err
+	}
+	return bs.releasePromo(ctx, booking)
+}
+
+// syncRefund доводит возврат в pending: если провайдер ещё не вернул ID возврата, запрос повторяется
+// с тем же ключом идемпотентности, иначе статус запрашивается у провайдера
+func (bs *BookingService) syncRefund(ctx context.Context, refund *models.Refund) (bool, error) {
+	if refund.ProviderRefundID == "" {
+		if err := bs.sendRefund(ctx, refund); err != nil {
+			return false, err
+		}
+		return refund.ProviderRefundID != "", nil
+	}
+
+	result, err := bs.paymentProvider.GetRefund(ctx, refund.ProviderRefundID)
+	if err != nil {
+		return false, err
+	}
+	status := models.RefundStatus(result.Status)
+	if status == models.RefundStatusPending {
+		return false, nil
+	}
+	return true, bs.applyRefundStatus(ctx, refund, status)
+}
+
+// refundUpdated запрашивает у провайдера статус возврата, о котором пришло уведомление, и применяет его
+func (bs *BookingService) refundUpdated(ctx context.Context, providerRefundID string) error {
+	refund, err := bs.refundRepo.FindByProviderID(ctx, providerRefundID)
+	if err != nil {
+		return err
+	}
+
+	result, err := bs.paymentProvider.GetRefund(ctx, providerRefundID)
+	if err != nil {
+		return err
+	}
+	return bs.applyRefundStatus(ctx, refund, models.RefundStatus(result.Status))
+}
+
+// buildRefundReceipt чек возврата: возвращаемые билеты и сбор или одна позиция на произвольную сумму
+func buildRefundReceipt(event *models.Event, refund *models.Refund, customer models.ReceiptCustomer, taxSystemCode int) *models.Receipt {
+	settings := event.FiscalSettings()
+	receipt := &models.Receipt{Customer: customer, TaxSystemCode: taxSystemCode}
+
+	itemized := refund.FeeAmount
+	for _, line := range refund.Lines {
+		itemized += line.Amount
+	}
+	if len(refund.Lines) == 0 || itemized != refund.Amount {
+		receipt.Items = receiptItems("Возврат: "+event.Name, refund.Amount, 1, refund.Currency, settings.VatCode, settings)
+		return receipt
+	}
+
+	for _, line := range refund.Lines {
+		if line.Amount == 0 {
+			continue
+		}
+		description := event.Name
+		for _, tt := range event.TicketTypes {
+			if tt.ID == line.TicketTypeID {
+				description += ": " + tt.Name
+			}
+		}
+		receipt.Items = append(receipt.Items, receiptItems(description, line.Amount, line.Quantity, refund.Currency, settings.VatCode, settings)...)
+	}
+	if refund.FeeAmount > 0 {
+		receipt.Items = append(receipt.Items, receiptItems("Сервисный сбор", refund.FeeAmount, 1, refund.Currency, settings.FeeVatCode, settings)...)
+	}
+	return receipt
+}
+
+func removeSeatIDs(ids, remove []string) []string {
+	drop := make(map[string]bool, len(remove))
+	for _, id := range remove {
+		drop[id] = true
+	}
+	var kept []string
+	for _, id := range ids {
+		if !drop[id] {
+			kept = append(kept, id)
+		}
+	}
+	return kept
+}
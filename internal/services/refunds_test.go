@@ -0,0 +1,231 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DrummDaddy/Booking_service/internal/models"
+	"github.com/DrummDaddy/Booking_service/internal/money"
+)
+
+// testBooking бронь на три билета по 1000.00 со скидкой 100.00 и сбором 100.00, оплачено 3000.00
+func testBooking() *models.Booking {
+	return &models.Booking{
+		Tickets: []models.BookingTicket{{
+			Quantity:   3,
+			UnitPrice:  money.Minor(100000),
+			TotalPrice: money.Minor(300000),
+		}},
+		Subtotal:    money.Minor(300000),
+		Discount:    money.Minor(10000),
+		ServiceFree: money.Minor(10000),
+		TotalAmount: money.Minor(300000),
+	}
+}
+
+func TestPlanRefundRepeatedPartialRefunds(t *testing.T) {
+	tests := []struct {
+		name       string
+		excludeFee bool
+		wantAmount []money.Amount
+		wantFee    []money.Amount
+	}{
+		{
+			name:       "tickets only",
+			excludeFee: true,
+			wantAmount: []money.Amount{96666, 96667, 96667},
+			wantFee:    []money.Amount{0, 0, 0},
+		},
+		{
+			// Сбор возвращается пропорционально числу билетов, остаток копеек с последним билетом
+			name:       "fee prorated",
+			wantAmount: []money.Amount{96666 + 3333, 96667 + 3333, 96667 + 3334},
+			wantFee:    []money.Amount{3333, 3333, 3334},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			booking := testBooking()
+			var total money.Amount
+			for i := range tt.wantAmount {
+				refund, err := planRefund(booking, booking.TotalAmount, models.RefundRequest{
+					Lines:      []models.TicketLineRequest{{TicketIndex: 0, Quantity: 1}},
+					ExcludeFee: tt.excludeFee,
+				})
+				if err != nil {
+					t.Fatalf("refund %d: %v", i, err)
+				}
+				if refund.Amount != tt.wantAmount[i] || refund.FeeAmount != tt.wantFee[i] {
+					t.Errorf("refund %d = %s (fee %s), want %s (fee %s)", i, refund.Amount, refund.FeeAmount, tt.wantAmount[i], tt.wantFee[i])
+				}
+				total += refund.Amount
+			}
+
+			want := money.Minor(290000)
+			if !tt.excludeFee {
+				want += booking.ServiceFree
+			}
+			if total != want || booking.RefundedAmount != want {
+				t.Errorf("refunded %s (booking %s), want %s", total, booking.RefundedAmount, want)
+			}
+			if booking.Tickets[0].RefundedQuantity != 3 {
+				t.Errorf("refunded quantity %d, want 3", booking.Tickets[0].RefundedQuantity)
+			}
+
+			if _, err := planRefund(booking, booking.TotalAmount, models.RefundRequest{}); err != ErrNothingToRefund {
+				t.Errorf("refund after all tickets: got %v, want ErrNothingToRefund", err)
+			}
+		})
+	}
+}
+
+func TestPlanRefundAmount(t *testing.T) {
+	tests := []struct {
+		name       string
+		refunded   money.Amount
+		amount     money.Amount
+		excludeFee bool
+		wantErr    bool
+	}{
+		{name: "whole payment", amount: 300000},
+		{name: "more than payment", amount: 300001, wantErr: true},
+		// Без сбора можно вернуть только оплату билетов: 3000.00 - 100.00
+		{name: "exclude fee within tickets", amount: 290000, excludeFee: true},
+		{name: "exclude fee clamps to tickets", amount: 290001, excludeFee: true, wantErr: true},
+		{name: "exclude fee after previous refund", refunded: 100000, amount: 190000, excludeFee: true},
+		{name: "exclude fee after previous refund exceeded", refunded: 100000, amount: 190001, excludeFee: true, wantErr: true},
+		{name: "negative", amount: -1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			booking := testBooking()
+			booking.RefundedAmount = tt.refunded
+
+			refund, err := planRefund(booking, booking.TotalAmount, models.RefundRequest{Amount: tt.amount, ExcludeFee: tt.excludeFee})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got refund %s", refund.Amount)
+				}
+				if booking.RefundedAmount != tt.refunded {
+					t.Errorf("refunded amount changed to %s", booking.RefundedAmount)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if refund.Amount != tt.amount || refund.FeeAmount != 0 || len(refund.Lines) != 0 {
+				t.Errorf("refund = %s (fee %s, %d lines), want %s", refund.Amount, refund.FeeAmount, len(refund.Lines), tt.amount)
+			}
+			if booking.RefundedAmount != tt.refunded+tt.amount {
+				t.Errorf("refunded amount %s, want %s", booking.RefundedAmount, tt.refunded+tt.amount)
+			}
+		})
+	}
+}
+
+func TestPlanRefundClampedByRemaining(t *testing.T) {
+	booking := testBooking()
+	// Компенсация уже вернула почти всё, возврат билетов ограничен остатком
+	booking.RefundedAmount = 250000
+
+	refund, err := planRefund(booking, booking.TotalAmount, models.RefundRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refund.Amount != 50000 || booking.RefundedAmount != booking.TotalAmount {
+		t.Errorf("refund %s, refunded %s, want 500.00 and %s", refund.Amount, booking.RefundedAmount, booking.TotalAmount)
+	}
+}
+
+func TestAllocateRefund(t *testing.T) {
+	created := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	// Доплата стоит первой, чтобы проверить, что основной платёж идёт раньше
+	payments := []models.Payment{
+		{ProviderPaymentID: "topup-2", Amount: 20000, Status: models.PaymentStatusSucceeded, CreatedAt: created.Add(2 * time.Hour)},
+		{ProviderPaymentID: "topup-1", Amount: 50000, Status: models.PaymentStatusSucceeded, CreatedAt: created.Add(time.Hour)},
+		{ProviderPaymentID: "canceled", Amount: 90000, Status: models.PaymentStatusCanceled, CreatedAt: created.Add(30 * time.Minute)},
+		{ProviderPaymentID: "main", Amount: 300000, Status: models.PaymentStatusSucceeded, CreatedAt: created},
+	}
+
+	type part struct {
+		paymentID string
+		amount    money.Amount
+	}
+	tests := []struct {
+		name    string
+		refunds []models.Refund
+		amount  money.Amount
+		want    []part
+		wantErr bool
+	}{
+		{
+			name:   "main payment only",
+			amount: 100000,
+			want:   []part{{"main", 100000}},
+		},
+		{
+			name:    "split across main and top-up",
+			refunds: []models.Refund{{PaymentID: "main", Amount: 250000, Status: models.RefundStatusSucceeded}},
+			amount:  80000,
+			want:    []part{{"main", 50000}, {"topup-1", 30000}},
+		},
+		{
+			name: "failed refunds do not count",
+			refunds: []models.Refund{
+				{PaymentID: "main", Amount: 250000, Status: models.RefundStatusFailed},
+				{PaymentID: "main", Amount: 100000, Status: models.RefundStatusPending},
+			},
+			amount: 200000,
+			want:   []part{{"main", 200000}},
+		},
+		{
+			name:    "exhausted main payment is skipped",
+			refunds: []models.Refund{{PaymentID: "main", Amount: 300000, Status: models.RefundStatusSucceeded}},
+			amount:  60000,
+			want:    []part{{"topup-1", 50000}, {"topup-2", 10000}},
+		},
+		{
+			name:   "free tickets go to main payment",
+			amount: 0,
+			want:   []part{{"main", 0}},
+		},
+		{
+			name:    "more than captured",
+			amount:  370001,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allocations, err := allocateRefund(payments, tt.refunds, "main", tt.amount)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %d parts", len(allocations))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []part
+			for _, allocation := range allocations {
+				got = append(got, part{allocation.payment.ProviderPaymentID, allocation.amount})
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("parts = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("parts = %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+
+	if payments[0].ProviderPaymentID != "topup-2" {
+		t.Error("allocateRefund reordered the caller's payments")
+	}
+}
@@ -0,0 +1,457 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/DrummDaddy/Booking_service/internal/models"
+	"github.com/DrummDaddy/Booking_service/internal/money"
+)
+
+type SandboxOutcome string
+
+const (
+	SandboxOutcomeSuccess        SandboxOutcome = "success"
+	SandboxOutcomeDecline        SandboxOutcome = "decline"
+	SandboxOutcomeTimeout        SandboxOutcome = "timeout"
+	SandboxOutcomeDelayedWebhook SandboxOutcome = "delayed_webhook"
+)
+
+var (
+	ErrSandboxTimeout         = errors.New("sandbox: payment provider timed out")
+	ErrSandboxPaymentNotFound = errors.New("sandbox: payment not found")
+	ErrSandboxRefundNotFound  = errors.New("sandbox: refund not found")
+)
+
+type SandboxConfig struct {
+	// PublicURL адрес сервиса, с которого открывается страница оплаты
+	PublicURL string
+	// WebhookURL куда песочница отправляет уведомления
+	WebhookURL string
+	// WebhookSecret ключ подписи уведомлений для WebhookVerifier. Если не задан, генерируется при запуске.
+	WebhookSecret string
+	Outcome       SandboxOutcome
+	WebhookDelay  time.Duration
+	Timeout       time.Duration
+}
+
+type sandboxPayment struct {
+	ID          string            `json:"id"`
+	Status      string            `json:"status"`
+	Paid        bool              `json:"paid"`
+	Capture     bool              `json:"-"`
+	Amount      money.Money       `json:"amount"`
+	Description string            `json:"description"`
+	Metadata    map[string]string `json:"metadata"`
+	CreatedAt   time.Time         `json:"created_at"`
+
+	Confirmation struct {
+		Type            string `json:"type"`
+		ConfirmationURL string `json:"confirmation_url"`
+		ReturnURL       string `json:"return_url"`
+	} `json:"confirmation"`
+}
+
+type sandboxRefund struct {
+	ID        string      `json:"id"`
+	PaymentID string      `json:"payment_id"`
+	Status    string      `json:"status"`
+	Amount    money.Money `json:"amount"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// SandboxProvider платёжный шлюз, работающий внутри процесса, со страницей оплаты-заглушкой.
+// Исход оплаты задаётся в SandboxConfig и может быть переопределён на странице оплаты.
+type SandboxProvider struct {
+	config SandboxConfig
+	client *http.Client
+
+	mu       sync.Mutex
+	payments map[string]*sandboxPayment
+	refunds  map[string]*sandboxRefund
+	byKey    map[string]string
+}
+
+var _ PaymentProvider = (*SandboxProvider)(nil)
+
+func NewSandboxProvider(config SandboxConfig) *SandboxProvider {
+	if config.Outcome == "" {
+		config.Outcome = SandboxOutcomeSuccess
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 30 * time.Second
+	}
+	// Уведомления песочницы всегда подписаны: адрес, с которого они придут, зависит от PublicURL и прокси
+	if config.WebhookSecret == "" {
+		config.WebhookSecret = randomHex(32)
+	}
+
+	return &SandboxProvider{
+		config:   config,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		payments: make(map[string]*sandboxPayment),
+		refunds:  make(map[string]*sandboxRefund),
+		byKey:    make(map[string]string),
+	}
+}
+
+func (sp *SandboxProvider) CreatePayment(ctx context.Context, req PaymentRequest) (*PaymentResult, error) {
+	if sp.config.Outcome == SandboxOutcomeTimeout {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(sp.config.Timeout):
+			return nil, ErrSandboxTimeout
+		}
+	}
+
+	if err := checkSandboxReceipt(req.Receipt, req.Amount); err != nil {
+		return nil, err
+	}
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	if id, ok := sp.byKey[req.IdempotenceKey]; ok && req.IdempotenceKey != "" {
+		return sp.paymentResult(sp.payments[id])
+	}
+
+	payment := &sandboxPayment{
+		ID:          "sandbox-" + randomHex(12),
+		Status:      "pending",
+		Amount:      money.New(req.Amount, req.Currency),
+		Description: req.Description,
+		Capture:     req.Capture,
+		Metadata:    map[string]string{"order_id": req.OrderID},
+		CreatedAt:   time.Now(),
+	}
+	payment.Confirmation.Type = "redirect"
+	payment.Confirmation.ConfirmationURL = sp.config.PublicURL + "/sandbox/checkout/" + payment.ID
+	payment.Confirmation.ReturnURL = req.ReturnURL
+
+	sp.payments[payment.ID] = payment
+	if req.IdempotenceKey != "" {
+		sp.byKey[req.IdempotenceKey] = payment.ID
+	}
+
+	return sp.paymentResult(payment)
+}
+
+func (sp *SandboxProvider) GetPayment(ctx context.Context, paymentID string) (*PaymentResult, error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	payment, ok := sp.payments[paymentID]
+	if !ok {
+		return nil, ErrSandboxPaymentNotFound
+	}
+	return sp.paymentResult(payment)
+}
+
+func (sp *SandboxProvider) CapturePayment(ctx context.Context, paymentID string, amount money.Money) (*PaymentResult, error) {
+	payment, err := sp.setStatus(paymentID, "waiting_for_capture", "succeeded")
+	if err != nil {
+		return nil, err
+	}
+	sp.notify("payment.succeeded", payment, 0)
+	return sp.lockedResult(payment)
+}
+
+func (sp *SandboxProvider) CancelPayment(ctx context.Context, paymentID string) (*PaymentResult, error) {
+	payment, err := sp.setStatus(paymentID, "pending", "canceled")
+	if errors.Is(err, errSandboxWrongStatus) {
+		payment, err = sp.setStatus(paymentID, "waiting_for_capture", "canceled")
+	}
+	if err != nil {
+		return nil, err
+	}
+	sp.notify("payment.canceled", payment, 0)
+	return sp.lockedResult(payment)
+}
+
+func (sp *SandboxProvider) CreateRefund(ctx context.Context, req RefundRequest) (*RefundResult, error) {
+	if err := checkSandboxReceipt(req.Receipt, req.Amount); err != nil {
+		return nil, sandboxRejection(err)
+	}
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	if id, ok := sp.byKey[req.IdempotenceKey]; ok && req.IdempotenceKey != "" {
+		return sp.refundResult(sp.refunds[id])
+	}
+
+	payment, ok := sp.payments[req.PaymentID]
+	if !ok {
+		return nil, sandboxRejection(ErrSandboxPaymentNotFound)
+	}
+	if payment.Status != "succeeded" {
+		return nil, sandboxRejection(fmt.Errorf("sandbox: cannot refund payment in status %s", payment.Status))
+	}
+	if req.Currency != payment.Amount.Currency {
+		return nil, sandboxRejection(money.ErrCurrencyMismatch)
+	}
+	// Как и провайдер, не даём вернуть больше, чем было списано
+	refunded := money.Amount(0)
+	for _, existing := range sp.refunds {
+		if existing.PaymentID == payment.ID && existing.Status != "canceled" {
+			refunded += existing.Amount.Amount
+		}
+	}
+	if req.Amount <= 0 || refunded+req.Amount > payment.Amount.Amount {
+		return nil, sandboxRejection(fmt.Errorf("sandbox: refund %s exceeds remaining payment amount %s", req.Amount, payment.Amount.Amount-refunded))
+	}
+
+	refund := &sandboxRefund{
+		ID:        "sandbox-refund-" + randomHex(12),
+		PaymentID: payment.ID,
+		Status:    "succeeded",
+		Amount:    money.New(req.Amount, req.Currency),
+		CreatedAt: time.Now(),
+	}
+	sp.refunds[refund.ID] = refund
+	if req.IdempotenceKey != "" {
+		sp.byKey[req.IdempotenceKey] = refund.ID
+	}
+
+	return sp.refundResult(refund)
+}
+
+func (sp *SandboxProvider) GetRefund(ctx context.Context, refundID string) (*RefundResult, error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	refund, ok := sp.refunds[refundID]
+	if !ok {
+		return nil, ErrSandboxRefundNotFound
+	}
+	return sp.refundResult(refund)
+}
+
+func (sp *SandboxProvider) ParseWebhook(body []byte) (*WebhookEvent, error) {
+	return parseNotification(body)
+}
+
+var checkoutTemplate = template.Must(template.New("checkout").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sandbox checkout</title></head>
+<body>
+<h1>Тестовая оплата</h1>
+<p>{{.Payment.Description}}</p>
+<p>Сумма: {{.Payment.Amount}}</p>
+<p>Статус: {{.Payment.Status}}</p>
+{{if eq .Payment.Status "pending"}}
+<form method="post">
+<select name="outcome">
+{{range .Outcomes}}<option value="{{.}}"{{if eq . $.Default}} selected{{end}}>{{.}}</option>{{end}}
+</select>
+<button type="submit">Оплатить</button>
+</form>
+{{end}}
+</body>
+</html>`))
+
+func (sp *SandboxProvider) CheckoutPage(w http.ResponseWriter, r *http.Request) {
+	sp.mu.Lock()
+	payment, ok := sp.payments[r.PathValue("id")]
+	var snapshot sandboxPayment
+	if ok {
+		snapshot = *payment
+	}
+	sp.mu.Unlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	defaultOutcome := sp.config.Outcome
+	if defaultOutcome == SandboxOutcomeTimeout {
+		defaultOutcome = SandboxOutcomeSuccess
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	checkoutTemplate.Execute(w, map[string]interface{}{
+		"Payment":  snapshot,
+		"Outcomes": []SandboxOutcome{SandboxOutcomeSuccess, SandboxOutcomeDecline, SandboxOutcomeDelayedWebhook},
+		"Default":  defaultOutcome,
+	})
+}
+
+func (sp *SandboxProvider) CheckoutSubmit(w http.ResponseWriter, r *http.Request) {
+	outcome := SandboxOutcome(r.FormValue("outcome"))
+	if outcome == "" {
+		outcome = sp.config.Outcome
+	}
+
+	status, event, delay := "succeeded", "payment.succeeded", time.Duration(0)
+	switch outcome {
+	case SandboxOutcomeSuccess:
+	case SandboxOutcomeDecline:
+		status, event = "canceled", "payment.canceled"
+	case SandboxOutcomeDelayedWebhook:
+		delay = sp.config.WebhookDelay
+	default:
+		http.Error(w, "unknown outcome", http.StatusBadRequest)
+		return
+	}
+
+	sp.mu.Lock()
+	if p, ok := sp.payments[r.PathValue("id")]; ok && !p.Capture && status == "succeeded" {
+		status, event = "waiting_for_capture", "payment.waiting_for_capture"
+	}
+	sp.mu.Unlock()
+
+	payment, err := sp.setStatus(r.PathValue("id"), "pending", status)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	sp.notify(event, payment, delay)
+
+	sp.mu.Lock()
+	returnURL := payment.Confirmation.ReturnURL
+	sp.mu.Unlock()
+
+	if returnURL == "" {
+		fmt.Fprintf(w, "payment %s: %s", payment.ID, status)
+		return
+	}
+	http.Redirect(w, r, returnURL, http.StatusSeeOther)
+}
+
+var errSandboxWrongStatus = errors.New("sandbox: payment is in wrong status")
+
+func (sp *SandboxProvider) setStatus(paymentID, from, to string) (*sandboxPayment, error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	payment, ok := sp.payments[paymentID]
+	if !ok {
+		return nil, ErrSandboxPaymentNotFound
+	}
+	if payment.Status != from {
+		return nil, errSandboxWrongStatus
+	}
+
+	payment.Status = to
+	payment.Paid = to == "succeeded" || to == "waiting_for_capture"
+	return payment, nil
+}
+
+// notify отправляет уведомление в формате YooKassa на WebhookURL, при delay > 0 с задержкой
+func (sp *SandboxProvider) notify(event string, payment *sandboxPayment, delay time.Duration) {
+	if sp.config.WebhookURL == "" {
+		return
+	}
+
+	sp.mu.Lock()
+	body, err := json.Marshal(map[string]interface{}{
+		"type":   "notification",
+		"event":  event,
+		"object": payment,
+	})
+	sp.mu.Unlock()
+	if err != nil {
+		log.Printf("sandbox: marshal notification: %v", err)
+		return
+	}
+
+	go func() {
+		if delay > 0 {
+			time.Sleep(delay)
+		}
+
+		req, err := http.NewRequest("POST", sp.config.WebhookURL, bytes.NewReader(body))
+		if err != nil {
+			log.Printf("sandbox: build %s for %s: %v", event, payment.ID, err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(WebhookSignatureHeader, hex.EncodeToString(SignWebhook([]byte(sp.config.WebhookSecret), body)))
+
+		resp, err := sp.client.Do(req)
+		if err != nil {
+			log.Printf("sandbox: send %s for %s: %v", event, payment.ID, err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("sandbox: webhook %s for %s returned %d", event, payment.ID, resp.StatusCode)
+		}
+	}()
+}
+
+func (sp *SandboxProvider) lockedResult(payment *sandboxPayment) (*PaymentResult, error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.paymentResult(payment)
+}
+
+func (sp *SandboxProvider) paymentResult(payment *sandboxPayment) (*PaymentResult, error) {
+	raw, err := json.Marshal(payment)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PaymentResult{
+		ID:              payment.ID,
+		Status:          payment.Status,
+		ConfirmationURL: payment.Confirmation.ConfirmationURL,
+		Raw:             raw,
+	}, nil
+}
+
+func (sp *SandboxProvider) refundResult(refund *sandboxRefund) (*RefundResult, error) {
+	raw, err := json.Marshal(refund)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RefundResult{
+		ID:        refund.ID,
+		PaymentID: refund.PaymentID,
+		Status:    refund.Status,
+		Raw:       raw,
+	}, nil
+}
+
+// WebhookSecret ключ, которым подписываются уведомления песочницы
+func (sp *SandboxProvider) WebhookSecret() string {
+	return sp.config.WebhookSecret
+}
+
+// sandboxRejection ошибка проверки запроса, как её вернул бы провайдер с кодом 400
+func sandboxRejection(err error) error {
+	return &ProviderError{StatusCode: http.StatusBadRequest, Body: err.Error()}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// checkSandboxReceipt повторяет проверку провайдера: сумма позиций чека должна совпадать с суммой операции
+func checkSandboxReceipt(receipt *models.Receipt, amount money.Amount) error {
+	if receipt == nil {
+		return nil
+	}
+	if receipt.Customer.Email == "" && receipt.Customer.Phone == "" {
+		return errors.New("sandbox: receipt customer email or phone is required")
+	}
+	if total := receipt.Total(); total != amount {
+		return fmt.Errorf("sandbox: receipt total %s does not match amount %s", total, amount)
+	}
+	return nil
+}
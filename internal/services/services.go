@@ -2,83 +2,127 @@ package services
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"net/http"
 	"time"
 
 	"github.com/DrummDaddy/Booking_service/internal/models"
+	"github.com/DrummDaddy/Booking_service/internal/money"
 	"github.com/DrummDaddy/Booking_service/internal/repositories"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrBookingNotFound    = errors.New("booking not found")
+	ErrReservationExpired = errors.New("reservation expired")
+)
+
+const (
+	defaultBookingsPageSize = 20
+	maxBookingsPageSize     = 100
 )
 
 type BookingService struct {
-	bookingRepo    *repositories.BookingRepository
-	eventRepo      *repositories.EventRepository
-	ticketRepo     *repositories.TicketRepository
-	paymentService *PaymentService
-	cache          *RedisCache
-	reservationTTL time.Duration
+	bookingRepo      *repositories.BookingRepository
+	eventRepo        *repositories.EventRepository
+	ticketRepo       *repositories.TicketRepository
+	seatRepo         *repositories.SeatRepository
+	paymentRepo      *repositories.PaymentRepository
+	notificationRepo *repositories.NotificationRepository
+	reviewRepo       *repositories.ReviewRepository
+	feePolicyRepo    *repositories.FeePolicyRepository
+	promoRepo        *repositories.PromoRepository
+	refundRepo       *repositories.RefundRepository
+	transactor       *repositories.Transactor
+	paymentProvider  PaymentProvider
+	cache            *RedisCache
 }
 
 func NewBookingService(
 	bookingRepo *repositories.BookingRepository,
 	eventRepo *repositories.EventRepository,
 	ticketRepo *repositories.TicketRepository,
-	paymentService *PaymentService,
+	seatRepo *repositories.SeatRepository,
+	paymentRepo *repositories.PaymentRepository,
+	notificationRepo *repositories.NotificationRepository,
+	reviewRepo *repositories.ReviewRepository,
+	feePolicyRepo *repositories.FeePolicyRepository,
+	promoRepo *repositories.PromoRepository,
+	refundRepo *repositories.RefundRepository,
+	transactor *repositories.Transactor,
+	paymentProvider PaymentProvider,
 ) *BookingService {
 	return &BookingService{
-		bookingRepo:    bookingRepo,
-		eventRepo:      eventRepo,
-		ticketRepo:     ticketRepo,
-		paymentService: paymentService,
-		reservationTTL: 15 * time.Minute,
+		bookingRepo:      bookingRepo,
+		eventRepo:        eventRepo,
+		ticketRepo:       ticketRepo,
+		seatRepo:         seatRepo,
+		paymentRepo:      paymentRepo,
+		notificationRepo: notificationRepo,
+		reviewRepo:       reviewRepo,
+		feePolicyRepo:    feePolicyRepo,
+		promoRepo:        promoRepo,
+		refundRepo:       refundRepo,
+		transactor:       transactor,
+		paymentProvider:  paymentProvider,
 	}
 }
 
 func (bs *BookingService) CreatingBooking(ctx context.Context, req *models.BookingRequest) (*models.BookingResponse, error) {
-	if err := bs.validateBookingRequest(ctx, req); err != nil {
-		return nil, err
-	}
-
-	eventObjID, err := primitive.ObjectIDFromHex(req.EventID)
-	if err != nil {
-		return nil, errors.New("invalid event ID format")
-	}
-	userObjID, err := primitive.ObjectIDFromHex(req.UserID)
-	if err != nil {
-		return nil, errors.New("invalid user ID format")
-	}
-	event, err := bs.eventRepo.FindByID(ctx, eventObjID)
-	if err != nil {
-		return nil, errors.New("event not found")
-	}
-	reservedTickets, err := bs.reserveTickets(ctx, event, req.Tickets)
+	plan, err := bs.planBooking(ctx, req)
 	if err != nil {
 		return nil, err
 	}
 
-	booking := &models.Booking{
-		ID:            primitive.NewObjectID(),
-		UserID:        userObjID,
-		EventID:       eventObjID,
-		Status:        models.BookingStatusReserved,
-		Tickets:       reservedTickets,
-		Subtotal:      bs.calculateSubtotal(reservedTickets),
-		ServiceFree:   bs.calculateServiceFee(reservedTickets),
-		TotalAmount:   0,
-		Currency:      "RUB",
-		ReservedUntil: time.Now().Add(bs.reservationTTL),
-		CreatedAt:     time.Now(),
-		UpdatedAt:     time.Now(),
-	}
+	bookingID := primitive.NewObjectID()
+	var booking *models.Booking
+	err = bs.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		reservedTickets, err := bs.reserveTickets(ctx, plan.event, bookingID, plan.currency, req.Tickets)
+		if err != nil {
+			return err
+		}
 
-	booking.TotalAmount = booking.Subtotal + booking.ServiceFree
+		totals, err := bs.calculateTotals(plan, reservedTickets)
+		if err != nil {
+			return err
+		}
+
+		booking = &models.Booking{
+			ID:            bookingID,
+			UserID:        plan.userID,
+			EventID:       plan.event.ID,
+			Status:        models.BookingStatusReserved,
+			Tickets:       reservedTickets,
+			Subtotal:      totals.subtotal,
+			Discount:      totals.discount,
+			ServiceFree:   totals.serviceFee,
+			Fees:          totals.fees,
+			FeeRules:      plan.feeRules,
+			TotalAmount:   totals.total,
+			Currency:      plan.currency,
+			ReservedUntil: time.Now().Add(plan.event.HoldPolicy().TTL()),
+			History: []models.StatusTransition{{
+				To:    models.BookingStatusReserved,
+				Actor: models.UserActor(req.UserID),
+				At:    time.Now(),
+			}},
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		}
 
-	if err := bs.bookingRepo.Create(ctx, booking); err != nil {
-		bs.releaseTickets(ctx, eventObjID, reservedTickets)
+		if promo := plan.promo; promo != nil {
+			// Погашение в той же транзакции: если бронь не создастся, счётчики откатятся
+			if err := bs.promoRepo.Redeem(ctx, promo, plan.userID); err != nil {
+				return err
+			}
+			booking.Promo = &models.BookingPromo{PromoID: promo.ID, Code: promo.Code, Type: promo.Type}
+		}
+
+		return bs.bookingRepo.Create(ctx, booking)
+	})
+	if err != nil {
 		return nil, err
 	}
 
@@ -86,7 +130,13 @@ func (bs *BookingService) CreatingBooking(ctx context.Context, req *models.Booki
 		BookingID:     booking.ID.Hex(),
 		Status:        booking.Status,
 		ReservedUntil: booking.ReservedUntil,
+		Subtotal:      booking.Subtotal,
+		Discount:      booking.Discount,
+		Promo:         booking.Promo,
+		ServiceFee:    booking.ServiceFree,
 		TotalAmount:   booking.TotalAmount,
+		Currency:      booking.Currency,
+		Fees:          booking.Fees,
 		Tickets:       booking.Tickets,
 	}
 	return response, nil
@@ -109,6 +159,9 @@ func (bs *BookingService) validateBookingRequest(ctx context.Context, req *model
 		if ticket.Quantity <= 0 {
 			return errors.New("invalid ticket quantity")
 		}
+		if len(ticket.Seats) > 0 && len(ticket.Seats) != ticket.Quantity {
+			return errors.New("number of seats must match ticket quantity")
+		}
 		totalTickets += ticket.Quantity
 	}
 	if totalTickets > 10 {
@@ -118,198 +171,435 @@ func (bs *BookingService) validateBookingRequest(ctx context.Context, req *model
 	return nil
 }
 
-func (bs *BookingService) reserveTickets(ctx context.Context, event *models.Event, ticketSelections []models.TicketSelection) ([]models.BookingTicket, error) {
+// reserveTickets резервирует билеты и фиксирует их цену в валюте брони.
+// Все билеты одной брони оплачиваются в одной валюте, поэтому тип билета без цены в ней отклоняется.
+// Если резерв пересекает границу ступеней цены, билеты одного типа разбиваются на строки по ступеням.
+func (bs *BookingService) reserveTickets(ctx context.Context, event *models.Event, bookingID primitive.ObjectID, currency string, ticketSelections []models.TicketSelection) ([]models.BookingTicket, error) {
 	var bookingTickets []models.BookingTicket
 
 	for _, selection := range ticketSelections {
-		ticketTypeID, err := primitive.ObjectIDFromHex(selection.TicketID)
+		ticketType, err := findTicketType(event, selection.TicketID)
 		if err != nil {
-			return nil, fmt.Errorf("invalid ticlet ID format: %s", selection.TicketID)
+			return nil, err
 		}
+		ticketTypeID := ticketType.ID
 
-		var ticketType *models.TicketType
-		for i, tt := range event.TicketTypes {
-			if tt.ID == ticketTypeID {
-				ticketType = &event.TicketTypes[i]
-				break
-			}
-		}
-		if ticketType == nil {
-			return nil, fmt.Errorf("ticket type %s not found", selection.TicketID)
+		if _, ok := ticketType.PriceIn(currency, event.BaseCurrency()); !ok {
+			return nil, fmt.Errorf("ticket type %s is not sold in %s", ticketType.Name, currency)
 		}
 
-		avalible := ticketType.Quantity - ticketType.SoldCount
-		if avalible < selection.Quantity {
-			return nil, fmt.Errorf("not enough tickets avalible for %s", &ticketType.Name)
+		if ticketType.Available() < selection.Quantity {
+			return nil, fmt.Errorf("not enough tickets avalible for %s", ticketType.Name)
+		}
+		// Ступень считается по счётчикам, которые были в базе в момент резерва, а не при чтении мероприятия
+		before, err := bs.ticketRepo.ReserveTickets(ctx, event.ID, ticketTypeID, selection.Quantity)
+		if err != nil {
+			if errors.Is(err, repositories.ErrNotEnoughTickets) {
+				return nil, fmt.Errorf("not enough tickets avalible for %s", ticketType.Name)
+			}
+			return nil, err
 		}
-		if err := bs.ticketRepo.ReserveTickets(ctx, event.ID, ticketTypeID, selection.Quantity); err != nil {
+		lines, err := priceLines(before, selection.Quantity, currency, event.BaseCurrency(), time.Now())
+		if err != nil {
 			return nil, err
 		}
 
-		bookingTicket := models.BookingTicket{
-			TicketTypeID:   ticketTypeID,
-			TicketTypeName: ticketType.Name,
-			Quantity:       selection.Quantity,
-			UnitPrice:      ticketType.Price,
-			TotalPrice:     ticketType.Price * float64(selection.Quantity),
-			Seats:          selection.Seats,
+		seats, err := bs.reserveSeats(ctx, event.ID, ticketType, bookingID, selection)
+		if err != nil {
+			return nil, err
 		}
 
-		bookingTickets = append(bookingTickets, bookingTicket)
+		for _, line := range lines {
+			bookingTicket := models.BookingTicket{
+				TicketTypeID:   ticketTypeID,
+				TicketTypeName: ticketType.Name,
+				Quantity:       line.quantity,
+				UnitPrice:      line.price,
+				TotalPrice:     line.price.Mul(line.quantity),
+				PriceTier:      line.tier,
+			}
+			if len(seats) > 0 {
+				bookingTicket.Seats, seats = seats[:line.quantity], seats[line.quantity:]
+			}
+
+			bookingTickets = append(bookingTickets, bookingTicket)
+		}
 	}
 	return bookingTickets, nil
 }
 
-func (bs *BookingService) calculateSubtotal(tickets []models.BookingTicket) float64 {
-	var subtotal float64
-	for _, ticket := range tickets {
-		subtotal += ticket.TotalPrice
+type priceLine struct {
+	tier     string
+	price    money.Amount
+	quantity int
+}
+
+// priceLines считает цену каждого из quantity билетов, начиная с позиции sold+reserved до резерва,
+// и группирует подряд идущие билеты с одинаковой ступенью
+func priceLines(ticketType *models.TicketType, quantity int, currency, baseCurrency string, at time.Time) ([]priceLine, error) {
+	var lines []priceLine
+	start := ticketType.SoldCount + ticketType.ReservedCount
+	for position := start; position < start+quantity; position++ {
+		price, tier, ok := ticketType.PriceAt(currency, baseCurrency, position, at)
+		if !ok {
+			return nil, fmt.Errorf("ticket type %s is not sold in %s", ticketType.Name, currency)
+		}
+
+		if n := len(lines); n > 0 && lines[n-1].tier == tier && lines[n-1].price == price {
+			lines[n-1].quantity++
+			continue
+		}
+		lines = append(lines, priceLine{tier: tier, price: price, quantity: 1})
 	}
-	return subtotal
+	return lines, nil
 }
 
-func (bs *BookingService) calculateServiceFee(tickets []models.BookingTicket) float64 {
-	var serviceFee float64
-	for _, ticket := range tickets {
-		fee := ticket.TotalPrice * 0.1
-		if fee < 50*float64(ticket.Quantity) {
-			fee = 50 * float64(ticket.Quantity)
+func (bs *BookingService) reserveSeats(ctx context.Context, eventID primitive.ObjectID, ticketType *models.TicketType, bookingID primitive.ObjectID, selection models.TicketSelection) ([]models.Seat, error) {
+	seatIDs, err := selectedSeatIDs(ticketType, selection)
+	if err != nil || len(seatIDs) == 0 {
+		return nil, err
+	}
+
+	reserved, err := bs.seatRepo.ReserveSeats(ctx, eventID, ticketType.ID, seatIDs, bookingID)
+	if err != nil {
+		return nil, err
+	}
+
+	seats := make([]models.Seat, 0, len(reserved))
+	for _, seat := range reserved {
+		seats = append(seats, seat.Seat())
+	}
+	return seats, nil
+}
+
+// selectedSeatIDs проверяет выбор мест для типа билета и возвращает их ID, nil для типов без мест
+func selectedSeatIDs(ticketType *models.TicketType, selection models.TicketSelection) ([]primitive.ObjectID, error) {
+	if !ticketType.AssignedSeating {
+		if len(selection.Seats) > 0 {
+			return nil, fmt.Errorf("ticket type %s has no assigned seating", ticketType.Name)
 		}
-		serviceFee += fee
+		return nil, nil
+	}
+	if len(selection.Seats) != selection.Quantity {
+		return nil, fmt.Errorf("ticket type %s requires %d seats to be selected", ticketType.Name, selection.Quantity)
 	}
 
-	return serviceFee
+	seatIDs := make([]primitive.ObjectID, 0, len(selection.Seats))
+	seen := make(map[primitive.ObjectID]bool, len(selection.Seats))
+	for _, seat := range selection.Seats {
+		seatID, err := primitive.ObjectIDFromHex(seat.SeatID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid seat ID format: %s", seat.SeatID)
+		}
+		if seen[seatID] {
+			return nil, fmt.Errorf("seat %s selected more than once", seat.SeatID)
+		}
+		seen[seatID] = true
+		seatIDs = append(seatIDs, seatID)
+	}
+	return seatIDs, nil
 }
 
-func (bs *BookingService) releaseTickets(ctx context.Context, eventID primitive.ObjectID, tickets []models.BookingTicket) {
+// calculateSubtotal возвращает стоимость билетов и скидку по промокоду отдельной строкой.
+// Сервисный сбор считается от стоимости билетов без скидки.
+func (bs *BookingService) calculateSubtotal(tickets []models.BookingTicket, promo *models.PromoCode, currency string) (money.Amount, money.Amount, error) {
+	var subtotal money.Amount
 	for _, ticket := range tickets {
-		bs.ticketRepo.ReleaseTickets(ctx, eventID, ticket.TicketTypeID, ticket.Quantity)
+		subtotal += ticket.TotalPrice
+	}
+	if promo == nil {
+		return subtotal, 0, nil
+	}
+
+	discount, err := calculateDiscount(promo, tickets, currency)
+	if err != nil {
+		return 0, 0, err
+	}
+	return subtotal, discount, nil
+}
+
+func (bs *BookingService) releaseTickets(ctx context.Context, booking *models.Booking) error {
+	for _, ticket := range booking.Tickets {
+		if err := bs.ticketRepo.ReleaseTickets(ctx, booking.EventID, ticket.TicketTypeID, ticket.Quantity); err != nil {
+			return err
+		}
+	}
+	if err := bs.seatRepo.ReleaseSeats(ctx, booking.ID); err != nil {
+		return err
 	}
+	return bs.releasePromo(ctx, booking)
 }
 
 func (bs *BookingService) Getbooking(ctx context.Context, bookingID string, userID string) (*models.Booking, error) {
-	bookingObjID, _ := primitive.ObjectIDFromHex(bookingID)
-	userObjID, _ := primitive.ObjectIDFromHex(userID)
+	bookingObjID, err := primitive.ObjectIDFromHex(bookingID)
+	if err != nil {
+		return nil, ErrBookingNotFound
+	}
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID format")
+	}
 
 	booking, err := bs.bookingRepo.FindByIDAndUser(ctx, bookingObjID, userObjID)
 	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrBookingNotFound
+		}
 		return nil, err
 	}
 
 	return booking, nil
 }
 
-func (bs *BookingService) ConfirmBooking(ctx context.Context, bookingID string, paymentID string) error {
-	bookingObjID, _ := primitive.ObjectIDFromHex(bookingID)
-
-	booking, err := bs.bookingRepo.FindByID(ctx, bookingObjID)
+func (bs *BookingService) ListBookings(ctx context.Context, req *models.BookingListRequest) (*models.BookingList, error) {
+	userObjID, err := primitive.ObjectIDFromHex(req.UserID)
 	if err != nil {
-		return err
+		return nil, errors.New("invalid user ID format")
 	}
 
-	if booking.Status != models.BookingStatusReserved {
-		return errors.New(" booking is not in reserved status")
+	filter := repositories.BookingFilter{
+		UserID:    userObjID,
+		Status:    req.Status,
+		Cursor:    req.Cursor,
+		Limit:     req.Limit,
+		Ascending: req.Ascending,
 	}
-
-	if time.Now().After(booking.ReservedUntil) {
-		bs.CancelBooking(ctx, bookingID, "reservation expires")
+	if req.EventID != "" {
+		if filter.EventID, err = primitive.ObjectIDFromHex(req.EventID); err != nil {
+			return nil, errors.New("invalid event ID format")
+		}
 	}
-
-	if err := bs.bookingRepo.UpdateStatus(ctx, bookingObjID, models.BookingStatusConfirmed); err != nil {
-		return err
+	switch {
+	case filter.Limit <= 0:
+		filter.Limit = defaultBookingsPageSize
+	case filter.Limit > maxBookingsPageSize:
+		filter.Limit = maxBookingsPageSize
 	}
 
-	for _, ticket := range booking.Tickets {
-		if err := bs.ticketRepo.ConfirmSale(ctx, booking.EventID, ticket.TicketTypeID, ticket.Quantity); err != nil {
-			return err
-		}
+	bookings, next, err := bs.bookingRepo.ListByUser(ctx, filter)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+
+	return &models.BookingList{Items: bookings, NextCursor: next}, nil
 }
 
-func (bs *BookingService) CancelBooking(ctx context.Context, bookingID string, reason string) error {
+func (bs *BookingService) ConfirmBooking(ctx context.Context, bookingID string, paymentID string) error {
 	bookingObjID, _ := primitive.ObjectIDFromHex(bookingID)
 
-	booking, err := bs.bookingRepo.FindByID(ctx, bookingObjID)
-	if err != nil {
-		return err
-	}
+	return bs.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		booking, err := bs.bookingRepo.FindByID(ctx, bookingObjID)
+		if err != nil {
+			return err
+		}
 
-	bs.releaseTickets(ctx, booking.EventID, booking.Tickets)
+		// Повторное подтверждение (например, повтор уведомления) ничего не меняет
+		if booking.Status == models.BookingStatusConfirmed {
+			return nil
+		}
 
-	if err := bs.bookingRepo.UpdateStatus(ctx, bookingObjID, models.BookingStatusCancelled); err != nil {
-		return err
-	}
+		// Просроченную бронь снимет ExpiryWorker, подтверждать её уже нельзя.
+		// Бронь в pending не истекает, пока идёт оплата.
+		if booking.Status == models.BookingStatusReserved && time.Now().After(booking.ReservedUntil) {
+			return ErrReservationExpired
+		}
 
-	return nil
+		if err := bs.transition(ctx, booking, models.BookingStatusConfirmed, "payment "+paymentID+" succeeded", models.ActorSystem); err != nil {
+			return err
+		}
+
+		for _, ticket := range booking.Tickets {
+			if err := bs.ticketRepo.ConfirmSale(ctx, booking.EventID, ticket.TicketTypeID, ticket.Quantity); err != nil {
+				return err
+			}
+		}
+		return bs.seatRepo.ConfirmSeats(ctx, booking.ID)
+	})
 }
 
-func (bs *BookingService) CreatePayment(ctx context.Context, bookingID string, returnURL string) (string, error) {
+func (bs *BookingService) CancelBooking(ctx context.Context, bookingID string, reason string, actor string) error {
 	bookingObjID, _ := primitive.ObjectIDFromHex(bookingID)
 
-	booking, err := bs.bookingRepo.FindByID(ctx, bookingObjID)
+	err := bs.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		booking, err := bs.bookingRepo.FindByID(ctx, bookingObjID)
+		if err != nil {
+			return err
+		}
+
+		return bs.cancel(ctx, booking, reason, actor)
+	})
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	if booking.Status != models.BookingStatusReserved {
-		return "", errors.New("only reserved bookings can be paid")
+	bs.voidOpenHolds(ctx, bookingObjID)
+	return nil
+}
 
+// CancelUserBooking отменяет бронь по запросу пользователя. Неоплаченная бронь отменяется сразу,
+// по оплаченной сначала оформляется возврат, а билеты освобождаются только после того, как провайдер его примет.
+func (bs *BookingService) CancelUserBooking(ctx context.Context, bookingID, userID, reason string) (*models.Booking, error) {
+	booking, err := bs.Getbooking(ctx, bookingID, userID)
+	if err != nil {
+		return nil, err
 	}
+	actor := models.UserActor(userID)
 
-	paymentURL, err := bs.paymentService.CreatePayment(
-		booking.ID.Hex(),
-		booking.TotalAmount,
-		booking.Currency,
-		"Оплата бронирования",
-		returnURL,
-	)
+	if booking.Status != models.BookingStatusConfirmed {
+		var cancelled *models.Booking
+		err := bs.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+			// Транзакция может повториться, поэтому бронь перечитывается в ней, а не меняется прочитанная снаружи
+			current, err := bs.bookingRepo.FindByID(ctx, booking.ID)
+			if err != nil {
+				return err
+			}
+			if current.Status == models.BookingStatusConfirmed {
+				return repositories.ErrStatusChanged
+			}
+			if err := bs.cancel(ctx, current, reason, actor); err != nil {
+				return err
+			}
+			cancelled = current
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		bs.voidOpenHolds(ctx, booking.ID)
+		return cancelled, nil
+	}
 
-	if err != nil {
-		return "", err
+	_, err = bs.refund(ctx, booking, models.RefundRequest{Reason: reason}, actor)
+	var partial *PartialRefundError
+	if err != nil && !errors.As(err, &partial) {
+		return nil, err
 	}
 
-	return paymentURL, nil
+	// Если провайдер сразу провёл возврат, бронь уже отменена в applyRefundStatus.
+	// При частичном отказе возвращаем бронь вместе с PartialRefundError.
+	current, findErr := bs.bookingRepo.FindByID(ctx, booking.ID)
+	if findErr != nil {
+		return nil, findErr
+	}
+	return current, err
 }
 
-func (bs *BookingService) ConfirmPayment(ctx context.Context, paymentID string) error {
-	status, err := bs.paymentService.GetPaymentStatus(paymentID)
-	if err != nil {
+// cancel переводит бронь в cancelled и возвращает её билеты и места в продажу. Вызывается в транзакции.
+func (bs *BookingService) cancel(ctx context.Context, booking *models.Booking, reason, actor string) error {
+	wasSold := booking.Status == models.BookingStatusConfirmed
+
+	if err := bs.transition(ctx, booking, models.BookingStatusCancelled, reason, actor); err != nil {
 		return err
 	}
 
-	if status != "succeeded" {
-		return errors.New("payment not completed")
+	if wasSold {
+		// Возвращённые ранее билеты уже вернулись в продажу при проведении возврата
+		for _, ticket := range booking.Tickets {
+			if ticket.ActiveQuantity() == 0 {
+				continue
+			}
+			if err := bs.ticketRepo.ReturnSoldTickets(ctx, booking.EventID, ticket.TicketTypeID, ticket.ActiveQuantity()); err != nil {
+				return err
+			}
+		}
+		if err := bs.seatRepo.ReleaseSeats(ctx, booking.ID); err != nil {
+			return err
+		}
+		return bs.releasePromo(ctx, booking)
 	}
 
-	booking, err := bs.bookingRepo.FindByPaymentID(ctx, paymentID)
+	return bs.releaseTickets(ctx, booking)
+}
+
+// transition проверяет переход по машине состояний и сохраняет его с проверкой текущего статуса
+func (bs *BookingService) transition(ctx context.Context, booking *models.Booking, to models.BookingStatus, reason, actor string) error {
+	transition, err := models.NewStatusTransition(booking.Status, to, reason, actor)
 	if err != nil {
 		return err
 	}
 
-	if booking.Status != models.BookingStatusReserved {
-		return errors.New("only reserved bookings can be confirmed")
-
+	if err := bs.bookingRepo.Transition(ctx, booking.ID, transition); err != nil {
+		return err
 	}
 
-	return bs.ConfirmBooking(ctx, booking.ID.Hex(), paymentID)
+	booking.Status = to
+	booking.UpdatedAt = transition.At
+	booking.History = append(booking.History, transition)
+	return nil
 }
 
-func (bs *BookingService) HandlerWebhook(w http.ResponseWriter, r *http.Request) {
-	var payload struct {
-		Object struct {
-			ID     string `json:"id"`
-			Status string `json:"status"`
-		} `json:"object"`
+func (bs *BookingService) GetEventInventory(ctx context.Context, eventID string) ([]models.TicketInventory, error) {
+	eventObjID, err := primitive.ObjectIDFromHex(eventID)
+	if err != nil {
+		return nil, errors.New("invalid event ID format")
 	}
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		http.Error(w, "invalid payload", http.StatusBadRequest)
-		return
+
+	event, err := bs.eventRepo.FindByID(ctx, eventObjID)
+	if err != nil {
+		return nil, errors.New("event not found")
 	}
 
-	if payload.Object.Status == "succeeded" {
-		_ = bs.ConfirmPayment(context.Background(), payload.Object.ID)
+	inventory := make([]models.TicketInventory, 0, len(event.TicketTypes))
+	for _, tt := range event.TicketTypes {
+		// Цены текущей ступени: по ним будет продан следующий билет
+		basePrice, otherPrices, tierName := tt.Price, tt.Prices, ""
+		if tier := tt.TierAt(tt.SoldCount+tt.ReservedCount, time.Now()); tier != nil {
+			basePrice, otherPrices, tierName = tier.Price, tier.Prices, tier.Name
+		}
+		prices := map[string]money.Amount{event.BaseCurrency(): basePrice}
+		for currency, price := range otherPrices {
+			prices[currency] = price
+		}
+
+		inventory = append(inventory, models.TicketInventory{
+			TicketTypeID:  tt.ID,
+			Name:          tt.Name,
+			Quantity:      tt.Quantity,
+			SoldCount:     tt.SoldCount,
+			ReservedCount: tt.ReservedCount,
+			Available:     tt.Available(),
+			Prices:        prices,
+			PriceTier:     tierName,
+		})
+	}
+	return inventory, nil
+}
+
+// ExpireReservation переводит просроченную бронь в expired и освобождает её билеты и места.
+// Возвращает бронь в том виде, в котором её освободили, или nil, если бронь уже обработал кто-то другой.
+func (bs *BookingService) ExpireReservation(ctx context.Context, bookingID primitive.ObjectID) (*models.Booking, error) {
+	var expired *models.Booking
+	err := bs.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		expired = nil
+		// Бронь перечитывается в транзакции: после выборки воркера из неё могли отменить или обменять билеты
+		booking, err := bs.bookingRepo.FindByID(ctx, bookingID)
+		if err != nil {
+			return err
+		}
+		if booking.Status != models.BookingStatusReserved {
+			return nil
+		}
+
+		// Обновление с условием на статус: на нескольких репликах бронь достанется только одной
+		transition, err := models.NewStatusTransition(booking.Status, models.BookingStatusExpired, "reservation expired", models.ActorSystem)
+		if err != nil {
+			return err
+		}
+		claimed, err := bs.bookingRepo.ExpireReservation(ctx, booking.ID, transition)
+		if err != nil || !claimed {
+			return err
+		}
+
+		if err := bs.releaseTickets(ctx, booking); err != nil {
+			return err
+		}
+		expired = booking
+		return nil
+	})
+	if err != nil || expired == nil {
+		return nil, err
 	}
-	w.WriteHeader(http.StatusOK)
 
+	bs.voidOpenHolds(ctx, bookingID)
+	return expired, nil
 }
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DrummDaddy/Booking_service/internal/models"
+	"github.com/DrummDaddy/Booking_service/internal/money"
+)
+
+func TestPriceLines(t *testing.T) {
+	tiers := []models.PriceTier{
+		{Name: "early", Price: 100000, Prices: map[string]money.Amount{money.USD: 1000}, UpTo: 2},
+		{Name: "regular", Price: 200000, UpTo: 4},
+	}
+	type line struct {
+		tier     string
+		price    money.Amount
+		quantity int
+	}
+	tests := []struct {
+		name     string
+		sold     int
+		reserved int
+		quantity int
+		currency string
+		want     []line
+		wantErr  bool
+	}{
+		{name: "within one tier", quantity: 2, currency: money.RUB, want: []line{{"early", 100000, 2}}},
+		// Резерв пересекает границы ступеней: билеты делятся на строки с разной ценой
+		{
+			name:     "across tiers",
+			sold:     1,
+			quantity: 5,
+			currency: money.RUB,
+			want:     []line{{"early", 100000, 1}, {"regular", 200000, 2}, {"", 300000, 2}},
+		},
+		{name: "reserved tickets count", reserved: 2, quantity: 1, currency: money.RUB, want: []line{{"regular", 200000, 1}}},
+		{name: "tier price in other currency", quantity: 1, currency: money.USD, want: []line{{"early", 1000, 1}}},
+		{name: "tier not sold in currency", sold: 2, quantity: 1, currency: money.USD, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ticketType := &models.TicketType{
+				Name:          "standard",
+				Price:         300000,
+				SoldCount:     tt.sold,
+				ReservedCount: tt.reserved,
+				PriceTiers:    tiers,
+			}
+
+			lines, err := priceLines(ticketType, tt.quantity, tt.currency, money.RUB, time.Now())
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %v", lines)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []line
+			for _, l := range lines {
+				got = append(got, line{l.tier, l.price, l.quantity})
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("lines = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("lines = %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+const WebhookSignatureHeader = "X-Webhook-Signature"
+
+var (
+	ErrWebhookSourceNotAllowed = errors.New("webhook source address is not allowed")
+	ErrWebhookBadSignature     = errors.New("webhook signature is invalid")
+)
+
+// Адреса, с которых YooKassa отправляет уведомления
+var YooKassaWebhookNetworks = []string{
+	"185.71.76.0/27",
+	"185.71.77.0/27",
+	"77.75.153.0/25",
+	"77.75.156.11/32",
+	"77.75.156.35/32",
+	"77.75.154.128/25",
+	"2a02:5180::/32",
+}
+
+// WebhookVerifier проверяет, что уведомление пришло от провайдера: по списку разрешённых сетей
+// и, если задан секрет, по HMAC-SHA256 подписи тела в заголовке X-Webhook-Signature.
+type WebhookVerifier struct {
+	networks          []netip.Prefix
+	secret            []byte
+	trustForwardedFor bool
+}
+
+func NewWebhookVerifier(networks []string, secret string, trustForwardedFor bool) (*WebhookVerifier, error) {
+	wv := &WebhookVerifier{
+		secret:            []byte(secret),
+		trustForwardedFor: trustForwardedFor,
+	}
+	for _, network := range networks {
+		network = strings.TrimSpace(network)
+		if network == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil {
+			return nil, err
+		}
+		wv.networks = append(wv.networks, prefix)
+	}
+
+	return wv, nil
+}
+
+func (wv *WebhookVerifier) Verify(r *http.Request, body []byte) error {
+	if len(wv.networks) > 0 && !wv.allowed(wv.sourceAddr(r)) {
+		return ErrWebhookSourceNotAllowed
+	}
+
+	if len(wv.secret) > 0 {
+		signature, err := hex.DecodeString(r.Header.Get(WebhookSignatureHeader))
+		if err != nil || !hmac.Equal(signature, SignWebhook(wv.secret, body)) {
+			return ErrWebhookBadSignature
+		}
+	}
+
+	return nil
+}
+
+func SignWebhook(secret, body []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+func (wv *WebhookVerifier) sourceAddr(r *http.Request) string {
+	if wv.trustForwardedFor {
+		// Левые адреса задаёт сам клиент, доверять можно только последнему, который дописал наш прокси
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			last := forwarded[len(forwarded)-1]
+			if i := strings.LastIndex(last, ","); i >= 0 {
+				last = last[i+1:]
+			}
+			return strings.TrimSpace(last)
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (wv *WebhookVerifier) allowed(addr string) bool {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+
+	for _, network := range wv.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,35 @@
+package services
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhookSourceAddr(t *testing.T) {
+	tests := []struct {
+		name      string
+		trust     bool
+		forwarded []string
+		want      string
+	}{
+		{name: "remote addr", forwarded: []string{"185.71.76.1"}, want: "10.0.0.1"},
+		{name: "single proxy", trust: true, forwarded: []string{"185.71.76.1"}, want: "185.71.76.1"},
+		// Клиент подставил разрешённый адрес слева, прокси дописал настоящий справа
+		{name: "spoofed left entry", trust: true, forwarded: []string{"185.71.76.1, 203.0.113.7"}, want: "203.0.113.7"},
+		{name: "several headers", trust: true, forwarded: []string{"185.71.76.1", "203.0.113.7"}, want: "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wv := &WebhookVerifier{trustForwardedFor: tt.trust}
+			r := httptest.NewRequest("POST", "/api/payments/webhook", nil)
+			r.RemoteAddr = "10.0.0.1:52000"
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+
+			if got := wv.sourceAddr(r); got != tt.want {
+				t.Errorf("sourceAddr = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,9 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/DrummDaddy/Booking_service/internal/handlers"
@@ -15,13 +19,16 @@ import (
 )
 
 func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	mongoURI := os.Getenv("MONGO_URI")
 	if mongoURI == "" {
 		mongoURI = "mongodb://localhost:27017"
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	connectCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
+	client, err := mongo.Connect(connectCtx, options.Client().ApplyURI(mongoURI))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -30,17 +37,189 @@ func main() {
 	bookingRepo := repositories.NewBookingRepository(db)
 	eventRepo := repositories.NewEventRepository(db)
 	ticketRepo := repositories.NewTicketRepository(db)
+	seatRepo := repositories.NewSeatRepository(db)
+	paymentRepo := repositories.NewPaymentRepository(db)
+	notificationRepo := repositories.NewNotificationRepository(db)
+	reviewRepo := repositories.NewReviewRepository(db)
+	feePolicyRepo := repositories.NewFeePolicyRepository(db)
+	promoRepo := repositories.NewPromoRepository(db)
+	refundRepo := repositories.NewRefundRepository(db)
+	transactor := repositories.NewTransactor(db)
 
-	// Это заглушка надо будет поставить конфиг платежного сервиса
-	paymentService := services.NewPaymentService("https://some-api", "demo")
+	if err := bookingRepo.CreateIndexes(connectCtx); err != nil {
+		log.Printf("create booking indexes: %v", err)
+	}
+	if err := seatRepo.CreateIndexes(connectCtx); err != nil {
+		log.Printf("create seat indexes: %v", err)
+	}
+	if err := paymentRepo.CreateIndexes(connectCtx); err != nil {
+		log.Printf("create payment indexes: %v", err)
+	}
+	if err := notificationRepo.CreateIndexes(connectCtx); err != nil {
+		log.Printf("create notification indexes: %v", err)
+	}
+	if err := reviewRepo.CreateIndexes(connectCtx); err != nil {
+		log.Printf("create review indexes: %v", err)
+	}
+	if err := feePolicyRepo.CreateIndexes(connectCtx); err != nil {
+		log.Printf("create fee policy indexes: %v", err)
+	}
+	if err := promoRepo.CreateIndexes(connectCtx); err != nil {
+		log.Printf("create promo indexes: %v", err)
+	}
+	if err := refundRepo.CreateIndexes(connectCtx); err != nil {
+		log.Printf("create refund indexes: %v", err)
+	}
 
-	bookingServise := services.NewBookingService(bookingRepo, eventRepo, ticketRepo, paymentService)
+	// Миграции данных выполняются до старта обработчиков, иначе они прочитают документы в старом формате
+	if err := repositories.NewMigrator(db).Run(ctx); err != nil {
+		log.Fatalf("migrations: %v", err)
+	}
+
+	redisAddr := os.Getenv("REDIS_ADDR")
+	if redisAddr == "" {
+		redisAddr = "localhost:6379"
+	}
+	redisCache := services.NewRedisCache(redisAddr, os.Getenv("REDIS_PASSWORD"), 0)
+	idempotencyStore := services.NewIdempotencyStore(redisCache,
+		durationFromEnv("IDEMPOTENCY_TTL", 24*time.Hour),
+		durationFromEnv("IDEMPOTENCY_PENDING_TTL", 5*time.Minute))
+
+	publicURL := os.Getenv("PUBLIC_URL")
+	if publicURL == "" {
+		publicURL = "http://localhost:8080"
+	}
+
+	webhookSecret := os.Getenv("WEBHOOK_SECRET")
+	var webhookNetworks []string
+
+	var paymentProvider services.PaymentProvider
+	var sandbox *services.SandboxProvider
+	switch os.Getenv("PAYMENT_PROVIDER") {
+	case "yookassa":
+		paymentProvider = services.NewPaymentService(os.Getenv("YOOKASSA_API_URL"), os.Getenv("YOOKASSA_API_KEY"),
+			durationFromEnv("PAYMENT_PROVIDER_TIMEOUT", 30*time.Second))
+		webhookNetworks = services.YooKassaWebhookNetworks
+	case "sandbox":
+		sandbox = services.NewSandboxProvider(services.SandboxConfig{
+			PublicURL:     publicURL,
+			WebhookURL:    publicURL + "/api/payments/webhook",
+			WebhookSecret: webhookSecret,
+			Outcome:       services.SandboxOutcome(os.Getenv("SANDBOX_OUTCOME")),
+			WebhookDelay:  durationFromEnv("SANDBOX_WEBHOOK_DELAY", 30*time.Second),
+			Timeout:       durationFromEnv("SANDBOX_TIMEOUT", 30*time.Second),
+		})
+		paymentProvider = sandbox
+		// Уведомления песочницы проверяются по подписи, а не по адресу отправителя
+		webhookSecret = sandbox.WebhookSecret()
+	case "":
+		// Песочница только по явному выбору, чтобы продакшен без настройки не принимал фиктивные оплаты
+		log.Fatalf("PAYMENT_PROVIDER is not set")
+	default:
+		log.Fatalf("unknown PAYMENT_PROVIDER %q", os.Getenv("PAYMENT_PROVIDER"))
+	}
+	if networks := os.Getenv("WEBHOOK_ALLOWED_NETWORKS"); networks != "" {
+		webhookNetworks = strings.Split(networks, ",")
+	}
+	webhookVerifier, err := services.NewWebhookVerifier(webhookNetworks, webhookSecret, os.Getenv("WEBHOOK_TRUST_FORWARDED_FOR") == "true")
+	if err != nil {
+		log.Fatalf("invalid WEBHOOK_ALLOWED_NETWORKS: %v", err)
+	}
+
+	bookingServise := services.NewBookingService(bookingRepo, eventRepo, ticketRepo, seatRepo, paymentRepo, notificationRepo, reviewRepo, feePolicyRepo, promoRepo, refundRepo, transactor, paymentProvider)
 	bookingHandler := &handlers.BookingHandler{Service: bookingServise}
+	eventHandler := &handlers.EventHandler{Service: bookingServise}
+	webhookHandler := &handlers.PaymentWebhookHandler{Service: bookingServise, Verifier: webhookVerifier}
+
+	expiryWorker := services.NewExpiryWorker(bookingRepo, bookingServise, durationFromEnv("EXPIRY_SWEEP_INTERVAL", time.Minute))
+	expiryWorker.Start(ctx)
+	reconciliationWorker := services.NewReconciliationWorker(
+		bookingServise,
+		durationFromEnv("RECONCILE_INTERVAL", 5*time.Minute),
+		durationFromEnv("RECONCILE_MIN_AGE", 15*time.Minute),
+	)
+	reconciliationWorker.Start(ctx)
+	adminAuth, err := handlers.NewAdminAuth(os.Getenv("ADMIN_TOKENS"))
+	if err != nil {
+		log.Fatalf("invalid ADMIN_TOKENS: %v", err)
+	}
+	if !adminAuth.Enabled() {
+		log.Println("ADMIN_TOKENS is not set, admin API is disabled")
+	}
+	adminHandler := &handlers.AdminHandler{
+		Service:              bookingServise,
+		ExpiryWorker:         expiryWorker,
+		ReconciliationWorker: reconciliationWorker,
+	}
+
+	http.HandleFunc("POST /api/bookings", handlers.Idempotent(idempotencyStore, "bookings", bookingHandler.CreateBooking))
+	http.HandleFunc("GET /api/bookings", bookingHandler.ListBookings)
+	http.HandleFunc("GET /api/bookings/{id}", bookingHandler.GetBooking)
+	http.HandleFunc("POST /api/bookings/{id}/cancel", bookingHandler.CancelBooking)
+	http.HandleFunc("POST /api/bookings/{id}/extend", bookingHandler.ExtendReservation)
+	http.HandleFunc("POST /api/bookings/{id}/tickets/cancel", handlers.Idempotent(idempotencyStore, "cancel-tickets", bookingHandler.CancelTickets))
+	http.HandleFunc("POST /api/bookings/{id}/modifications", handlers.Idempotent(idempotencyStore, "modifications", bookingHandler.ModifyBooking))
+	http.HandleFunc("GET /api/bookings/{id}/refunds", bookingHandler.ListRefunds)
+	http.HandleFunc("POST /api/quotes", bookingHandler.Quote)
+	http.HandleFunc("POST /api/payments", handlers.Idempotent(idempotencyStore, "payments", bookingHandler.CreatePayment))
+	http.HandleFunc("POST /api/payments/webhook", webhookHandler.HandleWebhook)
+	http.HandleFunc("GET /api/events/{id}/inventory", eventHandler.GetInventory)
+	// Административные методы регистрируются только через admin: без токена администратора они недоступны
+	admin := func(pattern string, handler http.HandlerFunc) {
+		http.HandleFunc(pattern, adminAuth.Wrap(handler))
+	}
+	admin("GET /api/admin/expiry/stats", adminHandler.ExpiryStats)
+	admin("GET /api/admin/reconcile/stats", adminHandler.ReconciliationStats)
+	admin("POST /api/admin/reconcile", adminHandler.Reconcile)
+	admin("GET /api/admin/payment-reviews", adminHandler.PaymentReviews)
+	admin("GET /api/admin/events/{id}/fee-policy", adminHandler.GetEventFeePolicy)
+	admin("PUT /api/admin/events/{id}/fee-policy", adminHandler.SetEventFeePolicy)
+	admin("PUT /api/admin/organizers/{id}/fee-policy", adminHandler.SetOrganizerFeePolicy)
+	admin("GET /api/admin/bookings/{id}/refunds", adminHandler.ListRefunds)
+	admin("POST /api/admin/bookings/{id}/refunds", handlers.Idempotent(idempotencyStore, "refunds", adminHandler.RefundBooking))
+	admin("GET /api/admin/promo-codes", adminHandler.ListPromoCodes)
+	admin("POST /api/admin/promo-codes", adminHandler.CreatePromoCode)
+	if sandbox != nil {
+		http.HandleFunc("GET /sandbox/checkout/{id}", sandbox.CheckoutPage)
+		http.HandleFunc("POST /sandbox/checkout/{id}", sandbox.CheckoutSubmit)
+	}
+
+	server := &http.Server{Addr: ":8080"}
+	go func() {
+		log.Println("Server running on port 8080")
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
 
-	http.HandleFunc("/api/bookings", bookingHandler.CreateBooking)
-	http.HandleFunc("api/payments", bookingHandler.CreatePayment)
-	http.HandleFunc("/api/payments/webhook", bookingServise.HandlerWebhook)
+	<-ctx.Done()
+	log.Println("Shutting down")
 
-	log.Println("Server running on port 8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelShutdown()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("http shutdown: %v", err)
+	}
+	expiryWorker.Stop()
+	reconciliationWorker.Stop()
+	if err := redisCache.Close(); err != nil {
+		log.Printf("redis close: %v", err)
+	}
+	if err := client.Disconnect(shutdownCtx); err != nil {
+		log.Printf("mongo disconnect: %v", err)
+	}
+}
+
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	// Нулевой или отрицательный интервал уронит time.NewTicker в воркерах
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("invalid %s=%q, using %s", key, value, fallback)
+		return fallback
+	}
+	return d
 }